@@ -0,0 +1,124 @@
+package homeassistant
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// AnomalyDetector decides if a new state for a sensor should be rejected
+type AnomalyDetector interface {
+	Detect(sensor *Sensor, state float64, at time.Time) error
+}
+
+// AnomalyHandler is called when a sensor rejects a state
+type AnomalyHandler func(sensor *Sensor, state float64, err error)
+
+// PercentageDetector rejects states deviating more than Threshold (0.1 = 10%)
+// from the moving average of the sensor
+type PercentageDetector struct {
+	Threshold float64
+}
+
+// Detect anomaly by percentage deviation from the moving average
+func (d PercentageDetector) Detect(sensor *Sensor, state float64, at time.Time) error {
+	ma, err := sensor.MovingAverage()
+	if err != nil || ma == 0 {
+		return nil
+	}
+	deviation := math.Abs((state - ma) / ma)
+	if deviation > d.Threshold {
+		return fmt.Errorf("Change %.1f%% bigger than %.1f%%", deviation*100, d.Threshold*100)
+	}
+	return nil
+}
+
+// ZScoreDetector rejects states with a z-score above Threshold calculated
+// over the stored states of the sensor
+type ZScoreDetector struct {
+	Threshold  float64
+	MinSamples int
+}
+
+// Detect anomaly by z-score
+func (d ZScoreDetector) Detect(sensor *Sensor, state float64, at time.Time) error {
+	states := sensor.States
+	if len(states) == 0 || len(states) < d.MinSamples {
+		return nil
+	}
+	sd := stdDev(states)
+	if sd == 0 {
+		return nil
+	}
+	z := math.Abs(state-mean(states)) / sd
+	if z > d.Threshold {
+		return fmt.Errorf("Z-score %.2f bigger than %.2f", z, d.Threshold)
+	}
+	return nil
+}
+
+// MADDetector rejects states with a modified z-score, based on the median
+// absolute deviation, above Threshold (3.5 is a common value)
+type MADDetector struct {
+	Threshold  float64
+	MinSamples int
+}
+
+// Detect anomaly by median absolute deviation
+func (d MADDetector) Detect(sensor *Sensor, state float64, at time.Time) error {
+	states := sensor.States
+	if len(states) == 0 || len(states) < d.MinSamples {
+		return nil
+	}
+	med := median(states)
+	deviations := make([]float64, len(states))
+	for i, s := range states {
+		deviations[i] = math.Abs(s - med)
+	}
+	mad := median(deviations)
+	if mad == 0 {
+		return nil
+	}
+	score := 0.6745 * math.Abs(state-med) / mad
+	if score > d.Threshold {
+		return fmt.Errorf("Modified z-score %.2f bigger than %.2f", score, d.Threshold)
+	}
+	return nil
+}
+
+// RangeDetector rejects states outside of Min and Max
+type RangeDetector struct {
+	Min float64
+	Max float64
+}
+
+// Detect anomaly by absolute range
+func (d RangeDetector) Detect(sensor *Sensor, state float64, at time.Time) error {
+	if state < d.Min || state > d.Max {
+		return fmt.Errorf("State %.2f outside of range %.2f to %.2f", state, d.Min, d.Max)
+	}
+	return nil
+}
+
+// RateOfChangeDetector rejects states changing faster than MaxRate per second
+// since the last state
+type RateOfChangeDetector struct {
+	MaxRate float64
+}
+
+// Detect anomaly by rate of change
+func (d RateOfChangeDetector) Detect(sensor *Sensor, state float64, at time.Time) error {
+	last := sensor.lastState()
+	if last.IsZero() {
+		return nil
+	}
+	elapsed := at.Sub(last).Seconds()
+	if elapsed <= 0 {
+		return nil
+	}
+	rate := math.Abs(state-sensor.State()) / elapsed
+	if rate > d.MaxRate {
+		return fmt.Errorf("Rate of change %.2f/s bigger than %.2f/s", rate, d.MaxRate)
+	}
+	return nil
+}
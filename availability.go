@@ -0,0 +1,68 @@
+package homeassistant
+
+import (
+	MQTT "github.com/eclipse/paho.mqtt.golang"
+	log "github.com/sirupsen/logrus"
+)
+
+// Availability modes for components with more than one availability topic
+const (
+	AvailabilityModeAll    = "all"
+	AvailabilityModeAny    = "any"
+	AvailabilityModeLatest = "latest"
+)
+
+const (
+	payloadAvailable    = "online"
+	payloadNotAvailable = "offline"
+)
+
+// Availability is an extra availability topic for a component
+type Availability struct {
+	Topic               string `json:"t"`
+	PayloadAvailable    string `json:"pl_avail,omitempty"`
+	PayloadNotAvailable string `json:"pl_not_avail,omitempty"`
+	ValueTemplate       string `json:"val_tpl,omitempty"`
+}
+
+// availabilityPublisher is implemented by components with their own availability
+type availabilityPublisher interface {
+	PublishAvailable(MQTT.Client) error
+	PublishUnavailable(MQTT.Client) error
+}
+
+// discoverAvailability returns the availability topic or list and mode for
+// the discover payload of c, the list is only used when entity availability,
+// a bridge or extra availability topics are configured
+func discoverAvailability(c Component, entityTopic string, entity bool, extra []Availability, mode string) (string, []Availability, string) {
+	var bridgeTopic string
+	if m := componentManager(c); m != nil {
+		bridgeTopic = m.GetAvailabilityTopic()
+	}
+	if !entity && len(extra) == 0 && bridgeTopic == "" {
+		return c.GetAvailabilityTopic(), nil, mode
+	}
+	list := []Availability{{Topic: c.GetAvailabilityTopic()}}
+	if bridgeTopic != "" {
+		list = append(list, Availability{Topic: bridgeTopic})
+		if mode == "" {
+			mode = AvailabilityModeAll
+		}
+	}
+	if entity && c.GetAvailabilityTopic() != entityTopic {
+		list = append(list, Availability{Topic: entityTopic})
+		if mode == "" {
+			mode = AvailabilityModeAll
+		}
+	}
+	list = append(list, extra...)
+	return "", list, mode
+}
+
+// publishAvailability sends a retained availability payload to topic
+func publishAvailability(broker MQTT.Client, name string, topic string, payload string) error {
+	token := broker.Publish(topic, 0, true, payload)
+	log.Infof("Publishing %s availability %s to %s", name, payload, topic)
+	token.Wait()
+	return token.Error()
+}
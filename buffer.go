@@ -0,0 +1,256 @@
+package homeassistant
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	MQTT "github.com/eclipse/paho.mqtt.golang"
+	log "github.com/sirupsen/logrus"
+)
+
+const spillFileName = "spill.jsonl"
+
+// eventPlatforms publish events on their state topic, every one of them matters
+var eventPlatforms = []string{"event", "device_automation", "tag"}
+
+// BufferedClient queues publishes while the wrapped client is disconnected
+// and replays them in order once it is connected again
+type BufferedClient struct {
+	MQTT.Client
+	// MaxMessages kept in memory, older messages are spilled or dropped
+	MaxMessages int
+	// MaxBytes of payload kept in memory, older messages are spilled or dropped
+	MaxBytes int
+	// MaxAge of queued messages, older messages are dropped, 0 keeps them
+	MaxAge time.Duration
+	// Coalesce keeps only the latest queued message per state topic or
+	// retained topic, events, triggers and tag scans are always kept
+	Coalesce bool
+	// SpillDir where messages not fitting in memory are written, optional
+	SpillDir string
+	mu       sync.Mutex
+	queue    []queuedMessage
+	// replayed is true when nothing was queued or spilled since the last replay
+	replayed bool
+	logger   *log.Entry
+}
+
+// queuedMessage is a publish waiting for the connection
+type queuedMessage struct {
+	Topic    string    `json:"topic"`
+	QoS      byte      `json:"qos"`
+	Retained bool      `json:"retained"`
+	Payload  []byte    `json:"payload"`
+	Time     time.Time `json:"time"`
+}
+
+// doneToken is a token for publishes that already completed
+type doneToken struct {
+	err error
+}
+
+func (t *doneToken) Wait() bool                     { return true }
+func (t *doneToken) WaitTimeout(time.Duration) bool { return true }
+func (t *doneToken) Error() error                   { return t.err }
+
+// NewBufferedClient wraps client with an outbound queue
+func NewBufferedClient(client MQTT.Client) *BufferedClient {
+	return &BufferedClient{
+		Client:      client,
+		MaxMessages: 1000,
+		MaxBytes:    4 << 20,
+		Coalesce:    true,
+		logger:      log.WithFields(log.Fields{"unit": "buffer"}),
+	}
+}
+
+// Publish forwards the message when connected, otherwise it is queued
+func (c *BufferedClient) Publish(topic string, qos byte, retained bool, payload interface{}) MQTT.Token {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.Client.IsConnectionOpen() {
+		err := c.replay()
+		if err == nil {
+			return c.Client.Publish(topic, qos, retained, payload)
+		}
+	}
+	var data []byte
+	switch p := payload.(type) {
+	case string:
+		data = []byte(p)
+	case []byte:
+		data = p
+	default:
+		var err error
+		data, err = json.Marshal(p)
+		if err != nil {
+			return &doneToken{err: err}
+		}
+	}
+	c.enqueue(queuedMessage{Topic: topic, QoS: qos, Retained: retained, Payload: data, Time: time.Now()})
+	return &doneToken{}
+}
+
+// Queued returns the number of messages queued in memory
+func (c *BufferedClient) Queued() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.queue)
+}
+
+// Replay publishes all queued messages, call it when the connection is restored
+func (c *BufferedClient) Replay() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.replay()
+}
+
+// coalescable returns true when only the latest message to topic matters
+func coalescable(topic string, retained bool) bool {
+	parts := strings.Split(topic, "/")
+	if len(parts) > 2 && parts[0] == "homeassistant" && containsString(eventPlatforms, parts[1]) {
+		return false
+	}
+	return retained || strings.HasSuffix(topic, "/state")
+}
+
+func (c *BufferedClient) enqueue(message queuedMessage) {
+	if c.Coalesce && coalescable(message.Topic, message.Retained) {
+		for i, m := range c.queue {
+			if m.Topic == message.Topic {
+				c.queue = append(c.queue[:i], c.queue[i+1:]...)
+				break
+			}
+		}
+	}
+	c.queue = append(c.queue, message)
+	c.replayed = false
+	c.expire(message.Time)
+	overflow := 0
+	if c.MaxMessages > 0 && len(c.queue) > c.MaxMessages {
+		overflow = len(c.queue) - c.MaxMessages
+	}
+	if c.MaxBytes > 0 {
+		size := 0
+		for _, m := range c.queue[overflow:] {
+			size += len(m.Payload)
+		}
+		for overflow < len(c.queue) && size > c.MaxBytes {
+			size -= len(c.queue[overflow].Payload)
+			overflow++
+		}
+	}
+	if overflow > 0 {
+		c.spill(c.queue[:overflow])
+		c.queue = c.queue[overflow:]
+	}
+}
+
+// expire drops messages older than MaxAge
+func (c *BufferedClient) expire(now time.Time) {
+	if c.MaxAge <= 0 {
+		return
+	}
+	start := 0
+	for start < len(c.queue) && now.Sub(c.queue[start].Time) > c.MaxAge {
+		start++
+	}
+	if start > 0 {
+		c.logger.Warnf("Dropping %d expired messages", start)
+		c.queue = c.queue[start:]
+	}
+}
+
+// spill writes messages to the spill file, or drops them without SpillDir
+func (c *BufferedClient) spill(messages []queuedMessage) {
+	if c.SpillDir == "" {
+		c.logger.Warnf("Dropping %d messages, queue is full", len(messages))
+		return
+	}
+	err := os.MkdirAll(c.SpillDir, 0755)
+	if err != nil {
+		c.logger.Warnf("Dropping %d messages: %s", len(messages), err)
+		return
+	}
+	f, err := os.OpenFile(filepath.Join(c.SpillDir, spillFileName), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		c.logger.Warnf("Dropping %d messages: %s", len(messages), err)
+		return
+	}
+	defer f.Close()
+	encoder := json.NewEncoder(f)
+	for _, m := range messages {
+		err = encoder.Encode(m)
+		if err != nil {
+			c.logger.Warnf("Dropping spilled message to %s: %s", m.Topic, err)
+		}
+	}
+}
+
+// spilled reads and removes the spill file
+func (c *BufferedClient) spilled() ([]queuedMessage, error) {
+	if c.SpillDir == "" {
+		return nil, nil
+	}
+	path := filepath.Join(c.SpillDir, spillFileName)
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var messages []queuedMessage
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var m queuedMessage
+		if err := json.Unmarshal(scanner.Bytes(), &m); err != nil {
+			return nil, err
+		}
+		messages = append(messages, m)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return messages, os.Remove(path)
+}
+
+// replay publishes spilled and queued messages in order, messages that fail
+// are kept in the queue
+func (c *BufferedClient) replay() error {
+	if c.replayed {
+		return nil
+	}
+	spilled, err := c.spilled()
+	if err != nil {
+		return err
+	}
+	if len(spilled) == 0 && len(c.queue) == 0 {
+		c.replayed = true
+		return nil
+	}
+	messages := append(spilled, c.queue...)
+	c.queue = nil
+	now := time.Now()
+	c.logger.Infof("Replaying %d queued messages", len(messages))
+	for i, m := range messages {
+		if c.MaxAge > 0 && now.Sub(m.Time) > c.MaxAge {
+			continue
+		}
+		token := c.Client.Publish(m.Topic, m.QoS, m.Retained, m.Payload)
+		token.Wait()
+		if token.Error() != nil {
+			c.queue = append(c.queue, messages[i:]...)
+			return token.Error()
+		}
+	}
+	c.replayed = true
+	return nil
+}
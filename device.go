@@ -1,6 +1,7 @@
 package homeassistant
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 
@@ -10,11 +11,15 @@ import (
 
 // Device represents the device
 type Device struct {
-	Ident        string      `json:"ids,omitempty"`
-	Name         string      `json:"name,omitempty"`
-	Components   []Component `json:"-"`
-	Manufacturer string      `json:"mf,omitempty"`
-	Model        string      `json:"mdl,omitempty"`
+	Ident           string      `json:"ids,omitempty"`
+	Name            string      `json:"name,omitempty"`
+	Components      []Component `json:"-"`
+	Manufacturer    string      `json:"mf,omitempty"`
+	Model           string      `json:"mdl,omitempty"`
+	DeviceDiscovery bool        `json:"-"`
+	SharedState     bool        `json:"-"`
+	Origin          *Origin     `json:"-"`
+	manager         *Manager
 }
 
 // AddSensor to the device
@@ -66,3 +71,151 @@ func (d *Device) PublishUnavailable(broker MQTT.Client) error {
 	token.Wait()
 	return nil
 }
+
+// GetDiscoverTopic returns the device discover topic
+func (d *Device) GetDiscoverTopic() string {
+	return fmt.Sprintf("homeassistant/device/%s/config", d.Ident)
+}
+
+// GetOrigin returns the origin of the device, falling back to the origin of
+// the Manager and DefaultOrigin
+func (d *Device) GetOrigin() Origin {
+	if d.Origin != nil {
+		return *d.Origin
+	}
+	if d.manager != nil && d.manager.Origin != nil {
+		return *d.manager.Origin
+	}
+	return DefaultOrigin
+}
+
+// GetDiscoverPayload generates the device discover payload json containing
+// all components
+func (d *Device) GetDiscoverPayload() ([]byte, error) {
+	components := map[string]map[string]interface{}{}
+	for _, c := range d.Components {
+		payload, err := c.GetDiscoverPayload()
+		if err != nil {
+			return nil, err
+		}
+		var component map[string]interface{}
+		err = json.Unmarshal(payload, &component)
+		if err != nil {
+			return nil, err
+		}
+		delete(component, "device")
+		delete(component, "o")
+		component["p"] = c.GetPlatform()
+		components[c.GetIdent()] = component
+	}
+	return json.Marshal(&struct {
+		Device     Device                            `json:"dev"`
+		Origin     Origin                            `json:"o"`
+		Components map[string]map[string]interface{} `json:"cmps"`
+	}{
+		Device:     *d,
+		Origin:     d.GetOrigin(),
+		Components: components,
+	})
+}
+
+// PublishDiscover publishes the device discover payload when DeviceDiscovery
+// is set, otherwise the discover payload of every component
+func (d *Device) PublishDiscover(broker MQTT.Client) error {
+	if !d.DeviceDiscovery {
+		for _, c := range d.Components {
+			err := c.PublishDiscover(broker)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	for _, c := range d.Components {
+		err := c.Validate()
+		if err != nil {
+			return err
+		}
+	}
+	payload, err := d.GetDiscoverPayload()
+	if err != nil {
+		return err
+	}
+	token := broker.Publish(d.GetDiscoverTopic(), 0, true, payload)
+	log.Infof("Publishing device %s discovery to %s", d.Name, d.GetDiscoverTopic())
+	log.Debug(string(payload))
+	token.Wait()
+	return token.Error()
+}
+
+// MigrateDiscovery moves the components from per entity discover topics to
+// the device discover topic without losing their entity settings in Home
+// Assistant, the old discover topics are cleared afterwards
+func (d *Device) MigrateDiscovery(broker MQTT.Client) error {
+	for _, c := range d.Components {
+		token := broker.Publish(c.GetDiscoverTopic(), 0, true, `{"migrate_discovery": true}`)
+		token.Wait()
+		if token.Error() != nil {
+			return token.Error()
+		}
+	}
+	d.DeviceDiscovery = true
+	err := d.PublishDiscover(broker)
+	if err != nil {
+		return err
+	}
+	for _, c := range d.Components {
+		log.Infof("Removing %s discovery from %s", c.GetName(), c.GetDiscoverTopic())
+		token := broker.Publish(c.GetDiscoverTopic(), 0, true, "")
+		token.Wait()
+		if token.Error() != nil {
+			return token.Error()
+		}
+	}
+	return nil
+}
+
+// sharedStateComponent is implemented by components with a value in the
+// shared device state
+type sharedStateComponent interface {
+	GetStateKey() string
+	GetStateValue() interface{}
+}
+
+// GetStateTopic returns the shared json state topic of the device
+func (d *Device) GetStateTopic() string {
+	return fmt.Sprintf("device/%s/state", d.Ident)
+}
+
+// sharesState returns true if a component with key publishes its state in the
+// shared device state, d may be nil
+func (d *Device) sharesState(key string) bool {
+	return d != nil && d.SharedState && key != ""
+}
+
+// stateValueTemplate extracts key from the shared device state, empty when
+// the component doesn't share state
+func (d *Device) stateValueTemplate(key string) string {
+	if !d.sharesState(key) {
+		return ""
+	}
+	return fmt.Sprintf("{{ value_json.%s }}", key)
+}
+
+// PublishState publishes the state of all components with a state key as one
+// json object to the shared state topic
+func (d *Device) PublishState(broker MQTT.Client) error {
+	state := map[string]interface{}{}
+	for _, c := range d.Components {
+		if s, ok := c.(sharedStateComponent); ok && s.GetStateKey() != "" {
+			state[s.GetStateKey()] = s.GetStateValue()
+		}
+	}
+	payload, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	token := broker.Publish(d.GetStateTopic(), 0, false, payload)
+	token.Wait()
+	return token.Error()
+}
@@ -0,0 +1,259 @@
+package homeassistant
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	MQTT "github.com/eclipse/paho.mqtt.golang"
+	log "github.com/sirupsen/logrus"
+)
+
+// Source types of a device tracker
+const (
+	SourceTypeGPS         = "gps"
+	SourceTypeRouter      = "router"
+	SourceTypeBluetooth   = "bluetooth"
+	SourceTypeBluetoothLE = "bluetooth_le"
+)
+
+// Device tracker states, any other state is the name of a zone
+const (
+	TrackerHome    = "home"
+	TrackerNotHome = "not_home"
+)
+
+// Location of a device tracker published as json attributes
+type Location struct {
+	Latitude    float64 `json:"latitude"`
+	Longitude   float64 `json:"longitude"`
+	GPSAccuracy float64 `json:"gps_accuracy,omitempty"`
+}
+
+// DeviceTracker HA device tracker for vehicles, people or anything else moving
+type DeviceTracker struct {
+	managed
+	Ident              string
+	Name               string
+	Device             *Device
+	Icon               string
+	SourceType         string
+	PayloadHome        string
+	PayloadNotHome     string
+	currentState       string
+	location           *Location
+	lastStateUpdate    time.Time
+	EntityAvailability bool
+	Availability       []Availability
+	AvailabilityMode   string
+}
+
+// NewDeviceTracker creates a new device tracker with source type gps
+func NewDeviceTracker(ident string) DeviceTracker {
+	t := DeviceTracker{
+		Ident:      ident,
+		SourceType: SourceTypeGPS,
+	}
+	return t
+}
+
+// GetDevice of tracker
+func (t *DeviceTracker) GetDevice() *Device {
+	return t.Device
+}
+
+// SetDevice of tracker
+func (t *DeviceTracker) SetDevice(device *Device) {
+	t.Device = device
+}
+
+// GetName of the tracker
+func (t *DeviceTracker) GetName() string {
+	var name string
+	if t.Name == "" {
+		name = t.Ident
+	} else {
+		name = t.Name
+	}
+	if t.Device != nil {
+		return fmt.Sprintf("%s %s", t.Device.Name, name)
+	}
+	return name
+}
+
+// State returns current state, home, not_home or a zone
+func (t *DeviceTracker) State() string {
+	return t.currentState
+}
+
+// SetState sets the tracker state to home, not_home or a zone name
+func (t *DeviceTracker) SetState(state string) {
+	t.currentState = state
+	t.lastStateUpdate = time.Now()
+}
+
+// Location returns the last location, nil if none is set
+func (t *DeviceTracker) Location() *Location {
+	return t.location
+}
+
+// SetLocation sets the location of the tracker
+func (t *DeviceTracker) SetLocation(location Location) {
+	t.location = &location
+	t.lastStateUpdate = time.Now()
+}
+
+// PublishState publishes the state and location to broker
+func (t *DeviceTracker) PublishState(broker MQTT.Client) error {
+	if t.currentState != "" {
+		state := t.currentState
+		switch state {
+		case TrackerHome:
+			state = orDefault(t.PayloadHome, TrackerHome)
+		case TrackerNotHome:
+			state = orDefault(t.PayloadNotHome, TrackerNotHome)
+		}
+		token := broker.Publish(t.GetStateTopic(), 0, false, state)
+		token.Wait()
+		if token.Error() != nil {
+			return token.Error()
+		}
+	}
+	if t.location != nil {
+		return publishEncoded(broker, t.GetAttributesTopic(), false, JSONPayload{Value: t.location})
+	}
+	return nil
+}
+
+// PublishLocation sets and publishes the location of the tracker
+func (t *DeviceTracker) PublishLocation(broker MQTT.Client, location Location) error {
+	t.SetLocation(location)
+	return publishEncoded(broker, t.GetAttributesTopic(), false, JSONPayload{Value: t.location})
+}
+
+// PublishZone sets and publishes the state of the tracker
+func (t *DeviceTracker) PublishZone(broker MQTT.Client, zone string) error {
+	t.SetState(zone)
+	return t.PublishState(broker)
+}
+
+// GetIdent of the tracker
+func (t *DeviceTracker) GetIdent() string {
+	if t.Device == nil {
+		return t.Ident
+	}
+	return fmt.Sprintf("%s_%s", t.Device.Ident, t.Ident)
+}
+
+// GetPlatform returns the Home Assistant platform of the tracker
+func (t *DeviceTracker) GetPlatform() string {
+	return "device_tracker"
+}
+
+// GetBaseTopic for broker
+func (t *DeviceTracker) GetBaseTopic() string {
+	return fmt.Sprintf("homeassistant/%s/%s", t.GetPlatform(), t.GetIdent())
+}
+
+// GetStateTopic returns state topic
+func (t *DeviceTracker) GetStateTopic() string {
+	return fmt.Sprintf("%s/state", t.GetBaseTopic())
+}
+
+// GetAttributesTopic returns the json attributes topic with the location
+func (t *DeviceTracker) GetAttributesTopic() string {
+	return fmt.Sprintf("%s/attributes", t.GetBaseTopic())
+}
+
+// GetAvailabilityTopic returns availability topic
+func (t *DeviceTracker) GetAvailabilityTopic() string {
+	if t.Device == nil {
+		return fmt.Sprintf("%s/availability", t.GetBaseTopic())
+	}
+	return t.Device.GetAvailabilityTopic()
+}
+
+// GetEntityAvailabilityTopic returns the availability topic of the tracker itself
+func (t *DeviceTracker) GetEntityAvailabilityTopic() string {
+	return fmt.Sprintf("%s/availability", t.GetBaseTopic())
+}
+
+// PublishAvailable send tracker availability message to broker
+func (t *DeviceTracker) PublishAvailable(broker MQTT.Client) error {
+	return publishAvailability(broker, t.GetName(), t.GetEntityAvailabilityTopic(), payloadAvailable)
+}
+
+// PublishUnavailable send tracker unavailability message to broker
+func (t *DeviceTracker) PublishUnavailable(broker MQTT.Client) error {
+	return publishAvailability(broker, t.GetName(), t.GetEntityAvailabilityTopic(), payloadNotAvailable)
+}
+
+// Validate checks the tracker ident, topics and that the source type is
+// one Home Assistant knows
+func (t *DeviceTracker) Validate() error {
+	var v validator
+	v.required("ident", t.Ident)
+	v.oneOf("source_type", t.SourceType, []string{SourceTypeGPS, SourceTypeRouter, SourceTypeBluetooth, SourceTypeBluetoothLE})
+	v.topic("state topic", t.GetStateTopic())
+	v.topic("attributes topic", t.GetAttributesTopic())
+	v.topic("discover topic", t.GetDiscoverTopic())
+	v.topic("availability topic", t.GetAvailabilityTopic())
+	v.availability(t.Availability, t.AvailabilityMode)
+	return v.err(t.GetName())
+}
+
+// PublishDiscover publish discover payload to MQTT
+func (t *DeviceTracker) PublishDiscover(broker MQTT.Client) error {
+	err := t.Validate()
+	if err != nil {
+		return err
+	}
+	payload, err := t.GetDiscoverPayload()
+	if err != nil {
+		return err
+	}
+	token := broker.Publish(t.GetDiscoverTopic(), 0, true, payload)
+	log.Infof("Publishing device tracker %s discovery to %s", t.GetName(), t.GetDiscoverTopic())
+	log.Debug(string(payload))
+	token.Wait()
+	return nil
+}
+
+// GetDiscoverTopic returns discover topic
+func (t *DeviceTracker) GetDiscoverTopic() string {
+	return fmt.Sprintf("%s/config", t.GetBaseTopic())
+}
+
+// GetDiscoverPayload generates discover payload json
+func (t *DeviceTracker) GetDiscoverPayload() ([]byte, error) {
+	availabilityTopic, availability, availabilityMode := discoverAvailability(t, t.GetEntityAvailabilityTopic(), t.EntityAvailability, t.Availability, t.AvailabilityMode)
+	return json.Marshal(&struct {
+		UniqueID          string         `json:"unique_id"`
+		Name              string         `json:"name"`
+		StateTopic        string         `json:"stat_t"`
+		AttributesTopic   string         `json:"json_attr_t"`
+		SourceType        string         `json:"src_type,omitempty"`
+		PayloadHome       string         `json:"pl_home,omitempty"`
+		PayloadNotHome    string         `json:"pl_not_home,omitempty"`
+		AvailabilityTopic string         `json:"avty_t,omitempty"`
+		Availability      []Availability `json:"avty,omitempty"`
+		AvailabilityMode  string         `json:"avty_mode,omitempty"`
+		Icon              string         `json:"icon,omitempty"`
+		Device            *Device        `json:"device,omitempty"`
+		Origin            Origin         `json:"o"`
+	}{
+		UniqueID:          t.GetIdent(),
+		Name:              t.GetName(),
+		StateTopic:        t.GetStateTopic(),
+		AttributesTopic:   t.GetAttributesTopic(),
+		SourceType:        t.SourceType,
+		PayloadHome:       t.PayloadHome,
+		PayloadNotHome:    t.PayloadNotHome,
+		AvailabilityTopic: availabilityTopic,
+		Availability:      availability,
+		AvailabilityMode:  availabilityMode,
+		Icon:              t.Icon,
+		Device:            t.Device,
+		Origin:            componentOrigin(t),
+	})
+}
@@ -0,0 +1,161 @@
+package homeassistant
+
+import (
+	"encoding/json"
+	"fmt"
+
+	MQTT "github.com/eclipse/paho.mqtt.golang"
+	log "github.com/sirupsen/logrus"
+)
+
+// DeviceTrigger HA device automation trigger, for example a button press
+type DeviceTrigger struct {
+	managed
+	Ident         string
+	Name          string
+	Device        *Device
+	Type          string
+	Subtype       string
+	Payload       string
+	ValueTemplate string
+}
+
+// NewDeviceTrigger creates a new device trigger with type and subtype, for
+// example button_short_press and button_1
+func NewDeviceTrigger(ident string, triggerType string, subtype string) DeviceTrigger {
+	t := DeviceTrigger{
+		Ident:   ident,
+		Type:    triggerType,
+		Subtype: subtype,
+	}
+	return t
+}
+
+// GetDevice of trigger
+func (t *DeviceTrigger) GetDevice() *Device {
+	return t.Device
+}
+
+// SetDevice of trigger
+func (t *DeviceTrigger) SetDevice(device *Device) {
+	t.Device = device
+}
+
+// GetName of the trigger
+func (t *DeviceTrigger) GetName() string {
+	var name string
+	if t.Name == "" {
+		name = t.Ident
+	} else {
+		name = t.Name
+	}
+	if t.Device != nil {
+		return fmt.Sprintf("%s %s", t.Device.Name, name)
+	}
+	return name
+}
+
+// GetIdent of the trigger
+func (t *DeviceTrigger) GetIdent() string {
+	if t.Device == nil {
+		return t.Ident
+	}
+	return fmt.Sprintf("%s_%s", t.Device.Ident, t.Ident)
+}
+
+// GetPlatform returns the Home Assistant platform of the trigger
+func (t *DeviceTrigger) GetPlatform() string {
+	return "device_automation"
+}
+
+// GetBaseTopic for broker
+func (t *DeviceTrigger) GetBaseTopic() string {
+	return fmt.Sprintf("homeassistant/%s/%s", t.GetPlatform(), t.GetIdent())
+}
+
+// GetStateTopic returns the topic the trigger is fired on
+func (t *DeviceTrigger) GetStateTopic() string {
+	return fmt.Sprintf("%s/state", t.GetBaseTopic())
+}
+
+// GetAvailabilityTopic returns availability topic, triggers don't use it in
+// their discover payload
+func (t *DeviceTrigger) GetAvailabilityTopic() string {
+	if t.Device == nil {
+		return fmt.Sprintf("%s/availability", t.GetBaseTopic())
+	}
+	return t.Device.GetAvailabilityTopic()
+}
+
+// PublishState does nothing, triggers have no state, use Fire
+func (t *DeviceTrigger) PublishState(broker MQTT.Client) error {
+	return nil
+}
+
+// Fire publishes the trigger payload, defaulting to the trigger type
+func (t *DeviceTrigger) Fire(broker MQTT.Client) error {
+	token := broker.Publish(t.GetStateTopic(), 0, false, orDefault(t.Payload, t.Type))
+	log.Debugf("Firing trigger %s %s %s", t.GetName(), t.Type, t.Subtype)
+	token.Wait()
+	return token.Error()
+}
+
+// Validate checks the trigger has a type, a subtype and a device, Home
+// Assistant ignores device triggers without one
+func (t *DeviceTrigger) Validate() error {
+	var v validator
+	v.required("ident", t.Ident)
+	v.required("type", t.Type)
+	v.required("subtype", t.Subtype)
+	if t.Device == nil {
+		v.addf("device is required")
+	}
+	v.topic("topic", t.GetStateTopic())
+	v.topic("discover topic", t.GetDiscoverTopic())
+	return v.err(t.GetName())
+}
+
+// PublishDiscover publish discover payload to MQTT
+func (t *DeviceTrigger) PublishDiscover(broker MQTT.Client) error {
+	err := t.Validate()
+	if err != nil {
+		return err
+	}
+	payload, err := t.GetDiscoverPayload()
+	if err != nil {
+		return err
+	}
+	token := broker.Publish(t.GetDiscoverTopic(), 0, true, payload)
+	log.Infof("Publishing trigger %s discovery to %s", t.GetName(), t.GetDiscoverTopic())
+	log.Debug(string(payload))
+	token.Wait()
+	return nil
+}
+
+// GetDiscoverTopic returns discover topic
+func (t *DeviceTrigger) GetDiscoverTopic() string {
+	return fmt.Sprintf("%s/config", t.GetBaseTopic())
+}
+
+// GetDiscoverPayload generates discover payload json
+func (t *DeviceTrigger) GetDiscoverPayload() ([]byte, error) {
+	return json.Marshal(&struct {
+		AutomationType string  `json:"atype"`
+		Topic          string  `json:"t"`
+		Type           string  `json:"type"`
+		Subtype        string  `json:"stype"`
+		Payload        string  `json:"pl,omitempty"`
+		ValueTemplate  string  `json:"val_tpl,omitempty"`
+		Device         *Device `json:"device,omitempty"`
+		Origin         Origin  `json:"o"`
+	}{
+		AutomationType: "trigger",
+		Topic:          t.GetStateTopic(),
+		Type:           t.Type,
+		Subtype:        t.Subtype,
+		Payload:        t.Payload,
+		ValueTemplate:  t.ValueTemplate,
+		Device:         t.Device,
+		Origin:         componentOrigin(t),
+	})
+}
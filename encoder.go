@@ -0,0 +1,45 @@
+package homeassistant
+
+import (
+	"encoding/json"
+	"errors"
+
+	MQTT "github.com/eclipse/paho.mqtt.golang"
+)
+
+// PayloadEncoder encodes a state payload, used to forward native device
+// payloads as-is together with a ValueTemplate
+type PayloadEncoder interface {
+	EncodePayload() ([]byte, error)
+}
+
+// RawPayload is a payload published unchanged
+type RawPayload []byte
+
+// EncodePayload returns the payload
+func (p RawPayload) EncodePayload() ([]byte, error) {
+	return p, nil
+}
+
+// JSONPayload is a value published as json
+type JSONPayload struct {
+	Value interface{}
+}
+
+// EncodePayload returns the value as json
+func (p JSONPayload) EncodePayload() ([]byte, error) {
+	return json.Marshal(p.Value)
+}
+
+var errSharedState = errors.New("Component publishes its state in the shared device state")
+
+// publishEncoded publishes the payload of encoder to topic
+func publishEncoded(broker MQTT.Client, topic string, retained bool, encoder PayloadEncoder) error {
+	payload, err := encoder.EncodePayload()
+	if err != nil {
+		return err
+	}
+	token := broker.Publish(topic, 0, retained, payload)
+	token.Wait()
+	return token.Error()
+}
@@ -0,0 +1,202 @@
+package homeassistant
+
+import (
+	"encoding/json"
+	"fmt"
+
+	MQTT "github.com/eclipse/paho.mqtt.golang"
+	log "github.com/sirupsen/logrus"
+)
+
+// Event HA event entity, for example the presses of a remote
+type Event struct {
+	managed
+	Ident              string
+	Name               string
+	Device             *Device
+	DeviceClass        string
+	Icon               string
+	EventTypes         []string
+	EntityAvailability bool
+	Availability       []Availability
+	AvailabilityMode   string
+}
+
+// NewEvent creates a new event entity firing eventTypes
+func NewEvent(ident string, eventTypes ...string) Event {
+	e := Event{
+		Ident:      ident,
+		EventTypes: eventTypes,
+	}
+	return e
+}
+
+// GetDevice of event
+func (e *Event) GetDevice() *Device {
+	return e.Device
+}
+
+// SetDevice of event
+func (e *Event) SetDevice(device *Device) {
+	e.Device = device
+}
+
+// GetName of the event
+func (e *Event) GetName() string {
+	var name string
+	if e.Name == "" {
+		name = e.Ident
+	} else {
+		name = e.Name
+	}
+	if e.Device != nil {
+		return fmt.Sprintf("%s %s", e.Device.Name, name)
+	}
+	return name
+}
+
+// GetIdent of the event
+func (e *Event) GetIdent() string {
+	if e.Device == nil {
+		return e.Ident
+	}
+	return fmt.Sprintf("%s_%s", e.Device.Ident, e.Ident)
+}
+
+// GetPlatform returns the Home Assistant platform of the event
+func (e *Event) GetPlatform() string {
+	return "event"
+}
+
+// GetBaseTopic for broker
+func (e *Event) GetBaseTopic() string {
+	return fmt.Sprintf("homeassistant/%s/%s", e.GetPlatform(), e.GetIdent())
+}
+
+// GetStateTopic returns state topic
+func (e *Event) GetStateTopic() string {
+	return fmt.Sprintf("%s/state", e.GetBaseTopic())
+}
+
+// GetAvailabilityTopic returns availability topic
+func (e *Event) GetAvailabilityTopic() string {
+	if e.Device == nil {
+		return fmt.Sprintf("%s/availability", e.GetBaseTopic())
+	}
+	return e.Device.GetAvailabilityTopic()
+}
+
+// GetEntityAvailabilityTopic returns the availability topic of the event itself
+func (e *Event) GetEntityAvailabilityTopic() string {
+	return fmt.Sprintf("%s/availability", e.GetBaseTopic())
+}
+
+// PublishAvailable send event availability message to broker
+func (e *Event) PublishAvailable(broker MQTT.Client) error {
+	return publishAvailability(broker, e.GetName(), e.GetEntityAvailabilityTopic(), payloadAvailable)
+}
+
+// PublishUnavailable send event unavailability message to broker
+func (e *Event) PublishUnavailable(broker MQTT.Client) error {
+	return publishAvailability(broker, e.GetName(), e.GetEntityAvailabilityTopic(), payloadNotAvailable)
+}
+
+// PublishState does nothing, events are only published by Fire
+func (e *Event) PublishState(broker MQTT.Client) error {
+	return nil
+}
+
+// Fire publishes an event of eventType with optional attributes
+func (e *Event) Fire(broker MQTT.Client, eventType string, attributes map[string]interface{}) error {
+	known := false
+	for _, t := range e.EventTypes {
+		if t == eventType {
+			known = true
+			break
+		}
+	}
+	if !known {
+		return fmt.Errorf("Unknown event type %s", eventType)
+	}
+	event := map[string]interface{}{}
+	for key, value := range attributes {
+		event[key] = value
+	}
+	event["event_type"] = eventType
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	token := broker.Publish(e.GetStateTopic(), 0, false, payload)
+	log.Debugf("Firing event %s %s", e.GetName(), eventType)
+	token.Wait()
+	return token.Error()
+}
+
+// Validate checks the event lists at least one event type and uses a known
+// device class
+func (e *Event) Validate() error {
+	var v validator
+	v.required("ident", e.Ident)
+	if len(e.EventTypes) == 0 {
+		v.addf("event_types is required")
+	}
+	v.oneOf("device_class", e.DeviceClass, []string{"button", "doorbell", "motion"})
+	v.topic("state topic", e.GetStateTopic())
+	v.topic("discover topic", e.GetDiscoverTopic())
+	v.topic("availability topic", e.GetAvailabilityTopic())
+	v.availability(e.Availability, e.AvailabilityMode)
+	return v.err(e.GetName())
+}
+
+// PublishDiscover publish discover payload to MQTT
+func (e *Event) PublishDiscover(broker MQTT.Client) error {
+	err := e.Validate()
+	if err != nil {
+		return err
+	}
+	payload, err := e.GetDiscoverPayload()
+	if err != nil {
+		return err
+	}
+	token := broker.Publish(e.GetDiscoverTopic(), 0, true, payload)
+	log.Infof("Publishing event %s discovery to %s", e.GetName(), e.GetDiscoverTopic())
+	log.Debug(string(payload))
+	token.Wait()
+	return nil
+}
+
+// GetDiscoverTopic returns discover topic
+func (e *Event) GetDiscoverTopic() string {
+	return fmt.Sprintf("%s/config", e.GetBaseTopic())
+}
+
+// GetDiscoverPayload generates discover payload json
+func (e *Event) GetDiscoverPayload() ([]byte, error) {
+	availabilityTopic, availability, availabilityMode := discoverAvailability(e, e.GetEntityAvailabilityTopic(), e.EntityAvailability, e.Availability, e.AvailabilityMode)
+	return json.Marshal(&struct {
+		UniqueID          string         `json:"unique_id"`
+		Name              string         `json:"name"`
+		StateTopic        string         `json:"stat_t"`
+		EventTypes        []string       `json:"evt_typ"`
+		AvailabilityTopic string         `json:"avty_t,omitempty"`
+		Availability      []Availability `json:"avty,omitempty"`
+		AvailabilityMode  string         `json:"avty_mode,omitempty"`
+		Icon              string         `json:"icon,omitempty"`
+		DeviceClass       string         `json:"dev_cla,omitempty"`
+		Device            *Device        `json:"device,omitempty"`
+		Origin            Origin         `json:"o"`
+	}{
+		UniqueID:          e.GetIdent(),
+		Name:              e.GetName(),
+		StateTopic:        e.GetStateTopic(),
+		EventTypes:        e.EventTypes,
+		AvailabilityTopic: availabilityTopic,
+		Availability:      availability,
+		AvailabilityMode:  availabilityMode,
+		Icon:              e.Icon,
+		DeviceClass:       e.DeviceClass,
+		Device:            e.Device,
+		Origin:            componentOrigin(e),
+	})
+}
@@ -1,11 +1,16 @@
 package homeassistant
 
-import MQTT "github.com/eclipse/paho.mqtt.golang"
+import (
+	"sync"
+
+	MQTT "github.com/eclipse/paho.mqtt.golang"
+)
 
 // Component interface for all sensors etc
 type Component interface {
 	GetName() string
 	GetIdent() string
+	GetPlatform() string
 	GetBaseTopic() string
 	GetStateTopic() string
 	PublishState(MQTT.Client) error
@@ -13,6 +18,46 @@ type Component interface {
 	GetDiscoverTopic() string
 	PublishDiscover(MQTT.Client) error
 	GetDiscoverPayload() ([]byte, error)
+	Validate() error
 	GetDevice() *Device
 	SetDevice(*Device)
 }
+
+// orDefault returns value or fallback when value is empty
+func orDefault(value string, fallback string) string {
+	if value == "" {
+		return fallback
+	}
+	return value
+}
+
+// containsString returns true when values contains value
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// lazyMutexInit guards allocating the mutex of a lazyMutex
+var lazyMutexInit sync.Mutex
+
+// lazyMutex is a mutex allocated on first use, so components holding one can
+// still be returned by value from their constructors
+type lazyMutex struct {
+	mu *sync.Mutex
+}
+
+// lock locks the mutex, call the returned function to unlock
+func (l *lazyMutex) lock() func() {
+	lazyMutexInit.Lock()
+	if l.mu == nil {
+		l.mu = &sync.Mutex{}
+	}
+	mu := l.mu
+	lazyMutexInit.Unlock()
+	mu.Lock()
+	return mu.Unlock
+}
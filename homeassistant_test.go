@@ -1,6 +1,20 @@
 package homeassistant
 
-import "testing"
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	MQTT "github.com/eclipse/paho.mqtt.golang"
+)
 
 func TestSensorState(t *testing.T) {
 	t.Run("Sensor state cap", func(t *testing.T) {
@@ -196,7 +210,7 @@ func TestDeviceAddSensor(t *testing.T) {
 	device := Device{Ident: "device01"}
 	t.Run("Sensor device is present after added to device", func(t *testing.T) {
 		sensor := NewSensor("sensor01")
-		device.AddSensor(&sensor)
+		device.AddComponent(&sensor)
 		want := &device
 		got := sensor.Device
 		if got != want {
@@ -204,3 +218,1622 @@ func TestDeviceAddSensor(t *testing.T) {
 		}
 	})
 }
+
+func TestAnomalyDetectors(t *testing.T) {
+	t.Run("Range detector rejects states outside range", func(t *testing.T) {
+		s := NewSensor("sensor01")
+		s.AnomalyDetector = RangeDetector{Min: -40, Max: 80}
+		if err := s.AddState(20); err != nil {
+			t.Errorf("Got error but didn't want one: %s", err)
+		}
+		if err := s.AddState(85); err == nil {
+			t.Errorf("Wanted error but didn't get any")
+		}
+	})
+
+	t.Run("Z-score detector", func(t *testing.T) {
+		s := NewSensor("sensor01")
+		s.States = []float64{20, 21, 20, 19, 20, 21}
+		d := ZScoreDetector{Threshold: 3}
+		if err := d.Detect(&s, 21, time.Now()); err != nil {
+			t.Errorf("Got error but didn't want one: %s", err)
+		}
+		if err := d.Detect(&s, 40, time.Now()); err == nil {
+			t.Errorf("Wanted error but didn't get any")
+		}
+	})
+
+	t.Run("MAD detector", func(t *testing.T) {
+		s := NewSensor("sensor01")
+		s.States = []float64{20, 21, 20, 19, 20, 21, 500}
+		d := MADDetector{Threshold: 3.5}
+		if err := d.Detect(&s, 22, time.Now()); err != nil {
+			t.Errorf("Got error but didn't want one: %s", err)
+		}
+		if err := d.Detect(&s, 30, time.Now()); err == nil {
+			t.Errorf("Wanted error but didn't get any")
+		}
+	})
+
+	t.Run("Rate of change detector", func(t *testing.T) {
+		s := NewSensor("sensor01")
+		s.AddState(20)
+		d := RateOfChangeDetector{MaxRate: 1}
+		at := s.lastState().Add(10 * time.Second)
+		if err := d.Detect(&s, 25, at); err != nil {
+			t.Errorf("Got error but didn't want one: %s", err)
+		}
+		if err := d.Detect(&s, 40, at); err == nil {
+			t.Errorf("Wanted error but didn't get any")
+		}
+	})
+
+	t.Run("Anomaly handler is called for rejected states", func(t *testing.T) {
+		s := NewSensor("sensor01")
+		s.AnomalyDetector = PercentageDetector{Threshold: 0.2}
+		var rejected []float64
+		s.AnomalyHandler = func(sensor *Sensor, state float64, err error) {
+			rejected = append(rejected, state)
+		}
+		s.AddState(10)
+		s.AddState(11)
+		s.AddState(100)
+		if len(rejected) != 1 || rejected[0] != 100 {
+			t.Errorf("got %v want [100]", rejected)
+		}
+		if s.State() != 11 {
+			t.Errorf("got %f want %f", s.State(), 11.0)
+		}
+	})
+}
+
+func TestSensorHistory(t *testing.T) {
+	t.Run("Configurable retention", func(t *testing.T) {
+		s := NewSensor("sensor01")
+		s.SetStateRetention(3)
+		for i := 0; i < 5; i++ {
+			s.AddState(float64(i))
+		}
+		got := s.Readings()
+		if len(got) != 3 || got[0].Value != 2 || got[0].Time.IsZero() {
+			t.Errorf("got %v want last 3 readings with time", got)
+		}
+	})
+
+	t.Run("Retention by age", func(t *testing.T) {
+		s := NewSensor("sensor01")
+		s.SetStateRetention(0)
+		s.SetStateMaxAge(time.Minute)
+		now := time.Now()
+		s.appendState(1, now.Add(-2*time.Minute))
+		s.appendState(2, now.Add(-30*time.Second))
+		s.appendState(3, now)
+		if len(s.States) != 2 || s.States[0] != 2 {
+			t.Errorf("got %v want [2 3]", s.States)
+		}
+	})
+
+	t.Run("Moving average window", func(t *testing.T) {
+		s := NewSensor("sensor01")
+		s.SetMovingAverageWindow(2)
+		s.States = []float64{1.0, 1.0, 10.0, 20.0}
+		got, _ := s.MovingAverage()
+		want := 15.0
+		if got != want {
+			t.Errorf("got %f want %f", got, want)
+		}
+	})
+
+	t.Run("Rolling statistics", func(t *testing.T) {
+		s := NewSensor("sensor01")
+		s.States = []float64{4, 1, 3, 2}
+		stats, err := s.Statistics()
+		if err != nil {
+			t.Fatalf("Got error but didn't want one: %s", err)
+		}
+		want := SensorStatistics{Min: 1, Max: 4, Mean: 2.5, Median: 2.5, StdDev: stats.StdDev, EMA: 2.375, TimeWeightedAverage: 2.5, Count: 4}
+		if stats != want {
+			t.Errorf("got %+v want %+v", stats, want)
+		}
+	})
+
+	t.Run("Time weighted average", func(t *testing.T) {
+		s := NewSensor("sensor01")
+		now := time.Now()
+		s.appendState(10, now.Add(-4*time.Second))
+		s.appendState(20, now.Add(-1*time.Second))
+		got, _ := s.timeWeightedAverage(now)
+		want := 12.5
+		if got != want {
+			t.Errorf("got %f want %f", got, want)
+		}
+	})
+}
+
+type fakeToken struct{}
+
+func (t *fakeToken) Wait() bool                     { return true }
+func (t *fakeToken) WaitTimeout(time.Duration) bool { return true }
+func (t *fakeToken) Error() error                   { return nil }
+
+type fakeMessage struct {
+	topic    string
+	payload  []byte
+	retained bool
+}
+
+func (m *fakeMessage) Duplicate() bool   { return false }
+func (m *fakeMessage) Qos() byte         { return 0 }
+func (m *fakeMessage) Retained() bool    { return m.retained }
+func (m *fakeMessage) Topic() string     { return m.topic }
+func (m *fakeMessage) MessageID() uint16 { return 0 }
+func (m *fakeMessage) Payload() []byte   { return m.payload }
+func (m *fakeMessage) Ack()              {}
+
+// fakeClient records everything published to it
+type fakeClient struct {
+	mu           sync.Mutex
+	disconnected bool
+	published    []fakeMessage
+	retained     map[string][]byte
+}
+
+func (c *fakeClient) IsConnected() bool      { return !c.disconnected }
+func (c *fakeClient) IsConnectionOpen() bool { return !c.disconnected }
+func (c *fakeClient) Connect() MQTT.Token    { return &fakeToken{} }
+func (c *fakeClient) Disconnect(uint)        {}
+func (c *fakeClient) Publish(topic string, qos byte, retained bool, payload interface{}) MQTT.Token {
+	var p []byte
+	switch v := payload.(type) {
+	case string:
+		p = []byte(v)
+	case []byte:
+		p = v
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.published = append(c.published, fakeMessage{topic: topic, payload: p, retained: retained})
+	if retained {
+		if c.retained == nil {
+			c.retained = map[string][]byte{}
+		}
+		c.retained[topic] = p
+	}
+	return &fakeToken{}
+}
+func (c *fakeClient) Subscribe(topic string, qos byte, callback MQTT.MessageHandler) MQTT.Token {
+	c.mu.Lock()
+	payload, ok := c.retained[topic]
+	c.mu.Unlock()
+	if ok && callback != nil {
+		go callback(c, &fakeMessage{topic: topic, payload: payload, retained: true})
+	}
+	return &fakeToken{}
+}
+func (c *fakeClient) SubscribeMultiple(map[string]byte, MQTT.MessageHandler) MQTT.Token {
+	return &fakeToken{}
+}
+func (c *fakeClient) Unsubscribe(...string) MQTT.Token        { return &fakeToken{} }
+func (c *fakeClient) AddRoute(string, MQTT.MessageHandler)    {}
+func (c *fakeClient) OptionsReader() MQTT.ClientOptionsReader { return MQTT.ClientOptionsReader{} }
+
+// last returns the last payload published to topic
+func (c *fakeClient) last(topic string) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i := len(c.published) - 1; i >= 0; i-- {
+		if c.published[i].topic == topic {
+			return string(c.published[i].payload), true
+		}
+	}
+	return "", false
+}
+
+func TestDerivedSensor(t *testing.T) {
+	device := Device{Ident: "device01", Name: "Device"}
+	voltage := NewSensor("voltage")
+	current := NewSensor("current")
+	power := NewDerivedSensor("power", func(inputs []*Sensor) (float64, error) {
+		return inputs[0].State() * inputs[1].State(), nil
+	}, &voltage, &current)
+	broker := &fakeClient{}
+	power.Broker = broker
+	device.AddComponent(&voltage)
+	device.AddComponent(&current)
+	device.AddComponent(&power)
+
+	t.Run("Not computed until every input has a state", func(t *testing.T) {
+		voltage.AddState(230)
+		if len(broker.published) != 0 {
+			t.Errorf("got %d publishes want 0", len(broker.published))
+		}
+	})
+
+	t.Run("Recomputed and published when an input changes", func(t *testing.T) {
+		current.AddState(2)
+		got, _ := broker.last("homeassistant/sensor/device01_power/state")
+		want := "460.0"
+		if got != want {
+			t.Errorf("got %s want %s", got, want)
+		}
+	})
+
+	t.Run("Published through the manager", func(t *testing.T) {
+		broker := &fakeClient{}
+		m := NewManager(broker)
+		device := Device{Ident: "device02"}
+		input := NewSensor("input")
+		double := NewDerivedSensor("double", func(inputs []*Sensor) (float64, error) {
+			return inputs[0].State() * 2, nil
+		}, &input)
+		device.AddComponent(&input)
+		device.AddComponent(&double)
+		m.AddDevice(&device)
+		input.AddState(21)
+		got, _ := broker.last(double.GetStateTopic())
+		if got != "42.0" {
+			t.Errorf("got %s want 42.0", got)
+		}
+	})
+
+	t.Run("Missing compute function", func(t *testing.T) {
+		s := NewDerivedSensor("broken", nil)
+		if err := s.Recompute(); err == nil {
+			t.Errorf("Wanted error but didn't get one")
+		}
+	})
+}
+
+func TestPublishPolicy(t *testing.T) {
+	t.Run("Relative deadband from zero", func(t *testing.T) {
+		p := PublishPolicy{RelativeDeadband: 0.05}
+		now := time.Now()
+		p.published(0, now)
+		if p.allow(0, now) {
+			t.Errorf("Published unchanged zero state")
+		}
+		if !p.allow(0.1, now) {
+			t.Errorf("Didn't publish change away from zero")
+		}
+	})
+
+	t.Run("Deadband", func(t *testing.T) {
+		p := PublishPolicy{Deadband: 0.5}
+		now := time.Now()
+		p.published(20, now)
+		if p.allow(20.4, now) {
+			t.Errorf("Published change inside deadband")
+		}
+		if !p.allow(20.6, now) {
+			t.Errorf("Didn't publish change outside deadband")
+		}
+	})
+
+	t.Run("Relative deadband", func(t *testing.T) {
+		p := PublishPolicy{RelativeDeadband: 0.1}
+		now := time.Now()
+		p.published(100, now)
+		if p.allow(109, now) {
+			t.Errorf("Published change inside deadband")
+		}
+		if !p.allow(111, now) {
+			t.Errorf("Didn't publish change outside deadband")
+		}
+	})
+
+	t.Run("Minimum interval and heartbeat", func(t *testing.T) {
+		p := PublishPolicy{MinInterval: 10 * time.Second, OnChangeOnly: true, Heartbeat: time.Minute}
+		now := time.Now()
+		p.published(1, now)
+		if p.allow(0, now.Add(5*time.Second)) {
+			t.Errorf("Published before minimum interval")
+		}
+		if !p.allow(0, now.Add(15*time.Second)) {
+			t.Errorf("Didn't publish change after minimum interval")
+		}
+		if p.allow(1, now.Add(30*time.Second)) {
+			t.Errorf("Published unchanged state")
+		}
+		if !p.allow(1, now.Add(time.Minute)) {
+			t.Errorf("Didn't publish heartbeat")
+		}
+	})
+
+	t.Run("Binary sensor publishes on change only", func(t *testing.T) {
+		broker := &fakeClient{}
+		s := NewBinarySensor("motion")
+		s.PublishPolicy.OnChangeOnly = true
+		s.SetState(true)
+		s.PublishState(broker)
+		s.PublishState(broker)
+		s.SetState(false)
+		s.PublishState(broker)
+		if len(broker.published) != 2 {
+			t.Errorf("got %d publishes want 2", len(broker.published))
+		}
+	})
+}
+
+func TestManagerPolling(t *testing.T) {
+	t.Run("Polled states are published", func(t *testing.T) {
+		broker := &fakeClient{}
+		device := Device{Ident: "device01"}
+		s := NewSensor("temperature")
+		s.Source = SourceFunc(func(ctx context.Context) (float64, error) {
+			return 21.5, nil
+		})
+		s.Poll = PollConfig{Interval: time.Millisecond}
+		device.AddComponent(&s)
+		m := NewManager(broker)
+		m.AddDevice(&device)
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+		m.Run(ctx)
+		got, _ := broker.last("homeassistant/sensor/device01_temperature/state")
+		want := "21.5"
+		if got != want {
+			t.Errorf("got %s want %s", got, want)
+		}
+	})
+
+	t.Run("Unavailable after repeated failures", func(t *testing.T) {
+		broker := &fakeClient{}
+		s := NewBinarySensor("door")
+		s.Source = BinarySourceFunc(func(ctx context.Context) (bool, error) {
+			return false, errors.New("Read failed")
+		})
+		s.Poll = PollConfig{Interval: time.Millisecond, MaxFailures: 2, MaxBackoff: 2 * time.Millisecond}
+		device := Device{Ident: "device01"}
+		device.AddComponent(&s)
+		m := NewManager(broker)
+		m.AddDevice(&device)
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+		m.Run(ctx)
+		got, _ := broker.last(s.GetEntityAvailabilityTopic())
+		want := "offline"
+		if got != want {
+			t.Errorf("got %s want %s", got, want)
+		}
+		payload, _ := s.GetDiscoverPayload()
+		var discovery struct {
+			Availability     []Availability `json:"avty"`
+			AvailabilityMode string         `json:"avty_mode"`
+		}
+		json.Unmarshal(payload, &discovery)
+		wantAvailability := []Availability{
+			{Topic: "device/device01/availability"},
+			{Topic: s.GetEntityAvailabilityTopic()},
+		}
+		if discovery.AvailabilityMode != "all" || !reflect.DeepEqual(discovery.Availability, wantAvailability) {
+			t.Errorf("Entity availability topic missing from discovery %s", payload)
+		}
+	})
+
+	t.Run("Backoff is capped", func(t *testing.T) {
+		c := PollConfig{Interval: time.Second, MaxBackoff: 5 * time.Second}
+		if got := c.backoff(1); got != 2*time.Second {
+			t.Errorf("got %s want %s", got, 2*time.Second)
+		}
+		if got := c.backoff(10); got != 5*time.Second {
+			t.Errorf("got %s want %s", got, 5*time.Second)
+		}
+	})
+}
+
+func TestAvailability(t *testing.T) {
+	t.Run("Single availability topic by default", func(t *testing.T) {
+		device := Device{Ident: "device01"}
+		s := NewSensor("sensor01")
+		device.AddComponent(&s)
+		payload, _ := s.GetDiscoverPayload()
+		var got map[string]interface{}
+		json.Unmarshal(payload, &got)
+		if got["avty_t"] != "device/device01/availability" || got["avty"] != nil {
+			t.Errorf("got %s", payload)
+		}
+	})
+
+	t.Run("Entity and extra availability topics", func(t *testing.T) {
+		device := Device{Ident: "device01"}
+		s := NewSwitch("relay")
+		s.EntityAvailability = true
+		s.AvailabilityMode = AvailabilityModeAll
+		s.Availability = []Availability{{Topic: "power/state", PayloadAvailable: "ON", PayloadNotAvailable: "OFF"}}
+		device.AddComponent(&s)
+		payload, _ := s.GetDiscoverPayload()
+		var got struct {
+			AvailabilityTopic string         `json:"avty_t"`
+			Availability      []Availability `json:"avty"`
+			AvailabilityMode  string         `json:"avty_mode"`
+		}
+		json.Unmarshal(payload, &got)
+		want := []Availability{
+			{Topic: "device/device01/availability"},
+			{Topic: "homeassistant/switch/device01_relay/availability"},
+			{Topic: "power/state", PayloadAvailable: "ON", PayloadNotAvailable: "OFF"},
+		}
+		if got.AvailabilityTopic != "" || got.AvailabilityMode != "all" || !reflect.DeepEqual(got.Availability, want) {
+			t.Errorf("got %s", payload)
+		}
+	})
+
+	t.Run("Entity unavailable", func(t *testing.T) {
+		broker := &fakeClient{}
+		device := Device{Ident: "device01"}
+		s := NewBinarySensor("door")
+		device.AddComponent(&s)
+		s.PublishUnavailable(broker)
+		got, _ := broker.last("homeassistant/binary_sensor/device01_door/availability")
+		if got != "offline" {
+			t.Errorf("got %s want offline", got)
+		}
+	})
+}
+
+func TestBridgeAvailability(t *testing.T) {
+	b := &Broker{URI: "tcp://localhost:1883", ClientID: "bridge"}
+	m := NewBridgeManager("bridge01", b)
+
+	t.Run("LWT defaults to bridge availability", func(t *testing.T) {
+		if b.WillTopic != "bridge/bridge01/availability" || b.WillMessage != "offline" {
+			t.Errorf("got %s %s", b.WillTopic, b.WillMessage)
+		}
+	})
+
+	t.Run("Discovery includes bridge availability", func(t *testing.T) {
+		device := Device{Ident: "device01"}
+		s := NewSensor("sensor01")
+		device.AddComponent(&s)
+		m.AddDevice(&device)
+		payload, _ := s.GetDiscoverPayload()
+		var got struct {
+			Availability     []Availability `json:"avty"`
+			AvailabilityMode string         `json:"avty_mode"`
+		}
+		json.Unmarshal(payload, &got)
+		want := []Availability{
+			{Topic: "device/device01/availability"},
+			{Topic: "bridge/bridge01/availability"},
+		}
+		if got.AvailabilityMode != "all" || !reflect.DeepEqual(got.Availability, want) {
+			t.Errorf("got %s", payload)
+		}
+	})
+}
+
+func TestSwitchCommand(t *testing.T) {
+	t.Run("State published when command succeeds", func(t *testing.T) {
+		broker := &fakeClient{}
+		s := NewSwitch("relay")
+		s.PayloadOn, s.PayloadOff = "1", "0"
+		s.StateOn, s.StateOff = "on", "off"
+		s.SubscribeCommand(broker, func(state bool) error { return nil })
+		s.CommandReceived(broker, &fakeMessage{payload: []byte("1")})
+		got, _ := broker.last(s.GetStateTopic())
+		if !s.State() || got != "on" {
+			t.Errorf("got %t %s want true on", s.State(), got)
+		}
+	})
+
+	t.Run("State unchanged when command fails", func(t *testing.T) {
+		broker := &fakeClient{}
+		s := NewSwitch("relay")
+		s.SubscribeCommand(broker, func(state bool) error { return errors.New("Relay stuck") })
+		err := s.HandleCommand(broker, "ON")
+		if err == nil || s.State() || len(broker.published) != 0 {
+			t.Errorf("State updated on failed command")
+		}
+	})
+
+	t.Run("Unknown payloads are rejected", func(t *testing.T) {
+		broker := &fakeClient{}
+		s := NewSwitch("relay")
+		s.SetState(true)
+		err := s.HandleCommand(broker, "TOGGLE")
+		if err == nil || !s.State() {
+			t.Errorf("Unknown payload not rejected")
+		}
+	})
+}
+
+func TestSwitchRestoreState(t *testing.T) {
+	t.Run("Restored from retained state", func(t *testing.T) {
+		broker := &fakeClient{}
+		s := NewSwitch("relay")
+		s.RetainState = true
+		s.SetState(true)
+		s.PublishState(broker)
+
+		restored := NewSwitch("relay")
+		restored.RestoreState(broker, time.Second)
+		if !restored.State() {
+			t.Errorf("got %t want %t", restored.State(), true)
+		}
+	})
+
+	t.Run("Default state without retained state", func(t *testing.T) {
+		broker := &fakeClient{}
+		s := NewSwitch("relay")
+		s.DefaultState = true
+		s.RestoreState(broker, 10*time.Millisecond)
+		if !s.State() {
+			t.Errorf("got %t want %t", s.State(), true)
+		}
+	})
+
+	t.Run("Shared state is not retained", func(t *testing.T) {
+		device := Device{Ident: "device01", SharedState: true}
+		s := NewSwitch("relay")
+		s.StateKey = "relay"
+		s.RetainState = true
+		device.AddComponent(&s)
+		if s.Validate() == nil {
+			t.Error("retain_state with a shared state key not rejected")
+		}
+	})
+}
+
+func TestFileStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "homeassistant")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	t.Run("Component states survive a restart", func(t *testing.T) {
+		store, _ := NewFileStore(dir)
+		device := Device{Ident: "device01"}
+		s := NewSensor("temperature")
+		s.AddState(20)
+		s.AddState(21)
+		r := NewSwitch("relay")
+		r.SetState(true)
+		device.AddComponent(&s)
+		device.AddComponent(&r)
+		m := NewManager(&fakeClient{})
+		m.Store = store
+		m.AddDevice(&device)
+		store.Save("counter", 42)
+		if err := m.SaveStates(); err != nil {
+			t.Fatalf("Got error but didn't want one: %s", err)
+		}
+
+		restarted, _ := NewFileStore(dir)
+		device = Device{Ident: "device01"}
+		s = NewSensor("temperature")
+		r = NewSwitch("relay")
+		device.AddComponent(&s)
+		device.AddComponent(&r)
+		m = NewManager(&fakeClient{})
+		m.Store = restarted
+		m.AddDevice(&device)
+		if err := m.LoadStates(); err != nil {
+			t.Fatalf("Got error but didn't want one: %s", err)
+		}
+		var counter int
+		restarted.Load("counter", &counter)
+		if s.State() != 21 || len(s.States) != 2 || !r.State() || counter != 42 {
+			t.Errorf("got %f %v %t %d", s.State(), s.States, r.State(), counter)
+		}
+		if r.lastState().IsZero() {
+			t.Error("switch update time not restored")
+		}
+	})
+
+	t.Run("Commands don't race with saving", func(t *testing.T) {
+		store, _ := NewFileStore(dir)
+		device := Device{Ident: "device02"}
+		r := NewSwitch("relay")
+		device.AddComponent(&r)
+		m := NewManager(&fakeClient{})
+		m.Store = store
+		m.AddDevice(&device)
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				r.HandleCommand(m.Client, "ON")
+				r.HandleCommand(m.Client, "OFF")
+			}
+		}()
+		for i := 0; i < 50; i++ {
+			if err := m.SaveStates(); err != nil {
+				t.Fatalf("Got error but didn't want one: %s", err)
+			}
+		}
+		wg.Wait()
+	})
+}
+
+func TestBufferedClient(t *testing.T) {
+	t.Run("Queued while disconnected and replayed in order", func(t *testing.T) {
+		broker := &fakeClient{disconnected: true}
+		c := NewBufferedClient(broker)
+		c.Publish("a/state", 0, false, "1")
+		c.Publish("b/state", 0, false, "2")
+		c.Publish("a/state", 0, false, "3")
+		if c.Queued() != 2 || len(broker.published) != 0 {
+			t.Fatalf("got %d queued and %d published", c.Queued(), len(broker.published))
+		}
+		broker.disconnected = false
+		c.Publish("c/state", 0, false, "4")
+		var got []string
+		for _, m := range broker.published {
+			got = append(got, m.topic+"="+string(m.payload))
+		}
+		want := []string{"b/state=2", "a/state=3", "c/state=4"}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("got %v want %v", got, want)
+		}
+	})
+
+	t.Run("Events are never coalesced", func(t *testing.T) {
+		broker := &fakeClient{disconnected: true}
+		c := NewBufferedClient(broker)
+		event := NewEvent("button", "press")
+		event.Fire(c, "press", nil)
+		event.Fire(c, "press", nil)
+		tag := NewTag("reader")
+		tag.Scanned(c, "0123")
+		tag.Scanned(c, "0123")
+		c.Publish("camera/image", 0, false, "frame1")
+		c.Publish("camera/image", 0, false, "frame2")
+		if c.Queued() != 6 {
+			t.Errorf("got %d queued want 6", c.Queued())
+		}
+	})
+
+	t.Run("Overflow is spilled to disk", func(t *testing.T) {
+		dir, err := ioutil.TempDir("", "homeassistant")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+		broker := &fakeClient{disconnected: true}
+		c := NewBufferedClient(broker)
+		c.MaxMessages = 1
+		c.SpillDir = dir
+		c.Publish("a/state", 0, false, "1")
+		c.Publish("b/state", 0, false, "2")
+		broker.disconnected = false
+		c.Replay()
+		if len(broker.published) != 2 || broker.published[0].topic != "a/state" {
+			t.Errorf("got %v", broker.published)
+		}
+	})
+
+	t.Run("Queue is capped by size", func(t *testing.T) {
+		broker := &fakeClient{disconnected: true}
+		c := NewBufferedClient(broker)
+		c.MaxBytes = 8
+		c.Publish("a/state", 0, false, "12345")
+		c.Publish("b/state", 0, false, "12345")
+		broker.disconnected = false
+		c.Replay()
+		if len(broker.published) != 1 || broker.published[0].topic != "b/state" {
+			t.Errorf("got %v", broker.published)
+		}
+	})
+
+	t.Run("Spill file of an earlier run is replayed", func(t *testing.T) {
+		dir, err := ioutil.TempDir("", "homeassistant")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+		earlier := NewBufferedClient(&fakeClient{disconnected: true})
+		earlier.MaxMessages = 1
+		earlier.SpillDir = dir
+		earlier.Publish("a/state", 0, false, "1")
+		earlier.Publish("b/state", 0, false, "2")
+
+		broker := &fakeClient{}
+		c := NewBufferedClient(broker)
+		c.SpillDir = dir
+		c.Publish("c/state", 0, false, "3")
+		c.Publish("d/state", 0, false, "4")
+		if len(broker.published) != 3 || broker.published[0].topic != "a/state" {
+			t.Errorf("got %v", broker.published)
+		}
+	})
+}
+
+func TestDeviceDiscovery(t *testing.T) {
+	device := Device{Ident: "device01", Name: "Device", DeviceDiscovery: true}
+	s := NewSensor("temperature")
+	r := NewSwitch("relay")
+	device.AddComponent(&s)
+	device.AddComponent(&r)
+
+	t.Run("Combined payload with all components", func(t *testing.T) {
+		payload, err := device.GetDiscoverPayload()
+		if err != nil {
+			t.Fatalf("Got error but didn't want one: %s", err)
+		}
+		var got struct {
+			Device     map[string]interface{}            `json:"dev"`
+			Origin     Origin                            `json:"o"`
+			Components map[string]map[string]interface{} `json:"cmps"`
+		}
+		json.Unmarshal(payload, &got)
+		if got.Device["ids"] != "device01" || got.Origin.Name != "homeassistant-go" {
+			t.Errorf("got %s", payload)
+		}
+		sensor := got.Components["device01_temperature"]
+		if sensor["p"] != "sensor" || sensor["device"] != nil || got.Components["device01_relay"]["p"] != "switch" {
+			t.Errorf("got %s", payload)
+		}
+	})
+
+	t.Run("Migration from per entity topics", func(t *testing.T) {
+		broker := &fakeClient{}
+		device.MigrateDiscovery(broker)
+		var got []string
+		for _, m := range broker.published {
+			got = append(got, m.topic)
+		}
+		want := []string{
+			"homeassistant/sensor/device01_temperature/config",
+			"homeassistant/switch/device01_relay/config",
+			"homeassistant/device/device01/config",
+			"homeassistant/sensor/device01_temperature/config",
+			"homeassistant/switch/device01_relay/config",
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("got %v want %v", got, want)
+		}
+		last, _ := broker.last("homeassistant/sensor/device01_temperature/config")
+		if last != "" {
+			t.Errorf("got %s want empty payload", last)
+		}
+	})
+}
+
+func TestOrigin(t *testing.T) {
+	origin := func(c Component) Origin {
+		payload, _ := c.GetDiscoverPayload()
+		var got struct {
+			Origin Origin `json:"o"`
+		}
+		json.Unmarshal(payload, &got)
+		return got.Origin
+	}
+
+	t.Run("Default origin", func(t *testing.T) {
+		device := Device{Ident: "device01"}
+		s := NewSensor("sensor01")
+		device.AddComponent(&s)
+		if got := origin(&s); got.Name != "homeassistant-go" {
+			t.Errorf("got %+v", got)
+		}
+	})
+
+	t.Run("Manager origin", func(t *testing.T) {
+		m := NewManager(&fakeClient{})
+		m.Origin = &Origin{Name: "bridge", SWVersion: "1.0"}
+		device := Device{Ident: "device01"}
+		s := NewBinarySensor("door")
+		device.AddComponent(&s)
+		m.AddDevice(&device)
+		if got := origin(&s); got != *m.Origin {
+			t.Errorf("got %+v want %+v", got, *m.Origin)
+		}
+	})
+
+	t.Run("Manager origin set after adding device", func(t *testing.T) {
+		m := NewManager(&fakeClient{})
+		device := Device{Ident: "device01"}
+		s := NewSensor("sensor01")
+		device.AddComponent(&s)
+		m.AddDevice(&device)
+		m.Origin = &Origin{Name: "bridge"}
+		if got := origin(&s); got.Name != "bridge" {
+			t.Errorf("got %+v want bridge", got)
+		}
+	})
+
+	t.Run("Device origin overrides manager origin", func(t *testing.T) {
+		m := NewManager(&fakeClient{})
+		m.Origin = &Origin{Name: "bridge"}
+		device := Device{Ident: "device01", Origin: &Origin{Name: "device"}}
+		s := NewSwitch("relay")
+		device.AddComponent(&s)
+		m.AddDevice(&device)
+		if got := origin(&s); got.Name != "device" {
+			t.Errorf("got %+v", got)
+		}
+	})
+}
+
+func TestSharedState(t *testing.T) {
+	broker := &fakeClient{}
+	device := Device{Ident: "device01", SharedState: true}
+	temperature := NewSensor("temperature")
+	temperature.StateKey = "temp"
+	door := NewBinarySensor("door")
+	door.StateKey = "door"
+	other := NewSensor("other")
+	device.AddComponent(&temperature)
+	device.AddComponent(&door)
+	device.AddComponent(&other)
+
+	t.Run("Components use the shared state topic", func(t *testing.T) {
+		payload, _ := temperature.GetDiscoverPayload()
+		var got map[string]interface{}
+		json.Unmarshal(payload, &got)
+		if got["stat_t"] != "device/device01/state" || got["val_tpl"] != "{{ value_json.temp }}" {
+			t.Errorf("got %s", payload)
+		}
+		if other.GetStateTopic() != "homeassistant/sensor/device01_other/state" {
+			t.Errorf("got %s", other.GetStateTopic())
+		}
+	})
+
+	t.Run("One json object is published", func(t *testing.T) {
+		temperature.AddState(21.5)
+		door.SetState(true)
+		temperature.PublishState(broker)
+		got, _ := broker.last("device/device01/state")
+		want := `{"door":"ON","temp":21.5}`
+		if got != want {
+			t.Errorf("got %s want %s", got, want)
+		}
+	})
+
+	t.Run("Publish policy applies to shared state", func(t *testing.T) {
+		broker := &fakeClient{}
+		temperature.PublishPolicy = PublishPolicy{Deadband: 1}
+		temperature.StatisticsAttributes = true
+		temperature.AddState(22)
+		temperature.PublishState(broker)
+		temperature.AddState(22.5)
+		temperature.PublishState(broker)
+		if got := len(broker.published); got != 2 {
+			t.Errorf("got %d publishes want 2", got)
+		}
+		if _, ok := broker.last(temperature.GetAttributesTopic()); !ok {
+			t.Error("statistics attributes not published")
+		}
+	})
+}
+
+func TestValueTemplates(t *testing.T) {
+	t.Run("Templates in discovery", func(t *testing.T) {
+		device := Device{Ident: "device01"}
+		s := NewSwitch("relay")
+		s.ValueTemplate = "{{ value_json.relay }}"
+		s.CommandTemplate = `{"relay": "{{ value }}"}`
+		device.AddComponent(&s)
+		payload, _ := s.GetDiscoverPayload()
+		var got map[string]interface{}
+		json.Unmarshal(payload, &got)
+		if got["val_tpl"] != s.ValueTemplate || got["cmd_tpl"] != s.CommandTemplate {
+			t.Errorf("got %s", payload)
+		}
+	})
+
+	t.Run("Pre-encoded payloads", func(t *testing.T) {
+		broker := &fakeClient{}
+		s := NewSensor("power")
+		s.ValueTemplate = "{{ value_json.power }}"
+		s.PublishEncoded(broker, RawPayload(`{"power":12}`))
+		s.PublishEncoded(broker, JSONPayload{Value: map[string]int{"power": 13}})
+		if len(broker.published) != 2 || string(broker.published[0].payload) != `{"power":12}` || string(broker.published[1].payload) != `{"power":13}` {
+			t.Errorf("got %v", broker.published)
+		}
+	})
+}
+
+func TestValidate(t *testing.T) {
+	t.Run("Valid sensor", func(t *testing.T) {
+		s := NewSensor("temperature")
+		s.DeviceClass = "temperature"
+		s.UnitOfMeasurement = "°C"
+		s.StateClass = "measurement"
+		if err := s.Validate(); err != nil {
+			t.Errorf("Got error but didn't want one: %s", err)
+		}
+	})
+
+	t.Run("Micro units", func(t *testing.T) {
+		for _, unit := range []string{"\u03bcg/m³", "\u00b5g/m³"} {
+			s := NewSensor("particles")
+			s.DeviceClass = "pm25"
+			s.UnitOfMeasurement = unit
+			if err := s.Validate(); err != nil {
+				t.Errorf("Got error for %q but didn't want one: %s", unit, err)
+			}
+		}
+	})
+
+	t.Run("Invalid sensor lists every error", func(t *testing.T) {
+		s := NewSensor("energy+total")
+		s.DeviceClass = "energy"
+		s.UnitOfMeasurement = "W"
+		s.StateClass = "measurement"
+		err := s.Validate()
+		verr, ok := err.(*ValidationError)
+		if !ok || len(verr.Errors) != 5 {
+			t.Errorf("got %v", err)
+		}
+	})
+
+	t.Run("Binary sensor device class in discovery", func(t *testing.T) {
+		s := NewBinarySensor("door")
+		s.DeviceClass = "door"
+		payload, _ := s.GetDiscoverPayload()
+		var got map[string]interface{}
+		json.Unmarshal(payload, &got)
+		if got["dev_cla"] != "door" {
+			t.Errorf("got %s", payload)
+		}
+	})
+
+	t.Run("Unknown binary sensor device class", func(t *testing.T) {
+		s := NewBinarySensor("door")
+		s.DeviceClass = "doorr"
+		if err := s.Validate(); err == nil {
+			t.Errorf("Wanted error but didn't get any")
+		}
+	})
+
+	t.Run("Switch device class", func(t *testing.T) {
+		s := NewSwitch("plug")
+		s.DeviceClass = "outlet"
+		payload, _ := s.GetDiscoverPayload()
+		var got map[string]interface{}
+		json.Unmarshal(payload, &got)
+		if err := s.Validate(); err != nil || got["dev_cla"] != "outlet" {
+			t.Errorf("got %v %s", err, payload)
+		}
+		s.DeviceClass = "socket"
+		if err := s.Validate(); err == nil {
+			t.Errorf("Wanted error but didn't get any")
+		}
+	})
+
+	t.Run("Invalid discover is not published", func(t *testing.T) {
+		broker := &fakeClient{}
+		device := Device{Ident: "device01"}
+		s := NewSwitch("relay")
+		s.PayloadOff = "ON"
+		device.AddComponent(&s)
+		if err := s.PublishDiscover(broker); err == nil || len(broker.published) != 0 {
+			t.Errorf("Invalid discover published")
+		}
+	})
+}
+
+func TestStandaloneComponents(t *testing.T) {
+	components := []Component{
+		&Sensor{Ident: "sensor01"},
+		&BinarySensor{Ident: "door"},
+		&Switch{Ident: "relay"},
+	}
+
+	for _, c := range components {
+		t.Run("Discover without device "+c.GetPlatform(), func(t *testing.T) {
+			payload, err := c.GetDiscoverPayload()
+			if err != nil {
+				t.Fatalf("Got error but didn't want one: %s", err)
+			}
+			var got map[string]interface{}
+			json.Unmarshal(payload, &got)
+			want := fmt.Sprintf("homeassistant/%s/%s/availability", c.GetPlatform(), c.GetIdent())
+			if got["device"] != nil || got["avty_t"] != want {
+				t.Errorf("got %s", payload)
+			}
+		})
+
+		t.Run("Discover with device "+c.GetPlatform(), func(t *testing.T) {
+			device := Device{Ident: "device01"}
+			c.SetDevice(&device)
+			defer c.SetDevice(nil)
+			payload, _ := c.GetDiscoverPayload()
+			var got map[string]interface{}
+			json.Unmarshal(payload, &got)
+			if got["device"] == nil || got["avty_t"] != "device/device01/availability" {
+				t.Errorf("got %s", payload)
+			}
+		})
+	}
+
+	t.Run("Published through the manager", func(t *testing.T) {
+		broker := &fakeClient{}
+		m := NewManager(broker)
+		for _, c := range components {
+			if err := m.AddComponent(c); err != nil {
+				t.Fatalf("Got error but didn't want one: %s", err)
+			}
+		}
+		if err := m.PublishDiscover(); err != nil {
+			t.Fatalf("Got error but didn't want one: %s", err)
+		}
+		if len(broker.published) != 3 {
+			t.Errorf("got %d publishes want 3", len(broker.published))
+		}
+		s := components[0].(*Sensor)
+		s.PublishAvailable(broker)
+		got, _ := broker.last(s.GetAvailabilityTopic())
+		if got != "online" {
+			t.Errorf("got %s want online", got)
+		}
+	})
+
+	t.Run("Bridge availability and manager origin", func(t *testing.T) {
+		m := NewManager(&fakeClient{})
+		m.Ident = "bridge01"
+		s := NewSensor("outdoor")
+		m.AddComponent(&s)
+		m.Origin = &Origin{Name: "bridge"}
+		payload, _ := s.GetDiscoverPayload()
+		var got struct {
+			Availability     []Availability `json:"avty"`
+			AvailabilityMode string         `json:"avty_mode"`
+			Origin           Origin         `json:"o"`
+		}
+		json.Unmarshal(payload, &got)
+		want := []Availability{
+			{Topic: "homeassistant/sensor/outdoor/availability"},
+			{Topic: "bridge/bridge01/availability"},
+		}
+		if got.AvailabilityMode != "all" || !reflect.DeepEqual(got.Availability, want) {
+			t.Errorf("got %s", payload)
+		}
+		if got.Origin.Name != "bridge" {
+			t.Errorf("got origin %+v want bridge", got.Origin)
+		}
+	})
+
+	t.Run("Components of a device are rejected", func(t *testing.T) {
+		m := NewManager(&fakeClient{})
+		device := Device{Ident: "device01"}
+		s := NewSensor("sensor01")
+		device.AddComponent(&s)
+		if err := m.AddComponent(&s); err == nil {
+			t.Errorf("Wanted error but didn't get any")
+		}
+	})
+}
+
+func TestTriggersAndEvents(t *testing.T) {
+	device := Device{Ident: "remote01"}
+	trigger := NewDeviceTrigger("button1_short", "button_short_press", "button_1")
+	event := NewEvent("button1", "single", "double", "long")
+	event.DeviceClass = "button"
+	device.AddComponent(&trigger)
+	device.AddComponent(&event)
+
+	t.Run("Trigger discovery", func(t *testing.T) {
+		payload, _ := trigger.GetDiscoverPayload()
+		var got map[string]interface{}
+		json.Unmarshal(payload, &got)
+		if got["atype"] != "trigger" || got["type"] != "button_short_press" || got["stype"] != "button_1" || got["t"] != trigger.GetStateTopic() {
+			t.Errorf("got %s", payload)
+		}
+		if err := trigger.Validate(); err != nil {
+			t.Errorf("Got error but didn't want one: %s", err)
+		}
+	})
+
+	t.Run("Trigger requires device", func(t *testing.T) {
+		standalone := NewDeviceTrigger("button", "button_short_press", "button_1")
+		if err := standalone.Validate(); err == nil {
+			t.Errorf("Wanted error but didn't get any")
+		}
+	})
+
+	t.Run("Fire trigger", func(t *testing.T) {
+		broker := &fakeClient{}
+		trigger.Fire(broker)
+		got, _ := broker.last(trigger.GetStateTopic())
+		if got != "button_short_press" {
+			t.Errorf("got %s want button_short_press", got)
+		}
+	})
+
+	t.Run("Fire event", func(t *testing.T) {
+		broker := &fakeClient{}
+		err := event.Fire(broker, "double", map[string]interface{}{"battery": 80})
+		got, _ := broker.last(event.GetStateTopic())
+		want := `{"battery":80,"event_type":"double"}`
+		if err != nil || got != want {
+			t.Errorf("got %s want %s", got, want)
+		}
+		if err := event.Fire(broker, "triple", nil); err == nil {
+			t.Errorf("Wanted error but didn't get any")
+		}
+	})
+}
+
+func TestTag(t *testing.T) {
+	broker := &fakeClient{}
+	device := Device{Ident: "door01"}
+	tag := NewTag("reader")
+	tag.ValueTemplate = "{{ value_json.id }}"
+	device.AddComponent(&tag)
+
+	t.Run("Tag discovery", func(t *testing.T) {
+		tag.PublishDiscover(broker)
+		got, _ := broker.last("homeassistant/tag/door01_reader/config")
+		var payload map[string]interface{}
+		json.Unmarshal([]byte(got), &payload)
+		if payload["t"] != "homeassistant/tag/door01_reader/state" || payload["val_tpl"] != tag.ValueTemplate {
+			t.Errorf("got %s", got)
+		}
+	})
+
+	t.Run("Scanned tag", func(t *testing.T) {
+		tag.Scanned(broker, "04:A2:3C:9B")
+		got, _ := broker.last(tag.GetStateTopic())
+		if got != "04:A2:3C:9B" {
+			t.Errorf("got %s want 04:A2:3C:9B", got)
+		}
+	})
+}
+
+func TestDeviceTracker(t *testing.T) {
+	broker := &fakeClient{}
+	device := Device{Ident: "car01"}
+	tracker := NewDeviceTracker("location")
+	device.AddComponent(&tracker)
+
+	t.Run("Tracker discovery", func(t *testing.T) {
+		payload, _ := tracker.GetDiscoverPayload()
+		var got map[string]interface{}
+		json.Unmarshal(payload, &got)
+		if got["src_type"] != "gps" || got["json_attr_t"] != "homeassistant/device_tracker/car01_location/attributes" {
+			t.Errorf("got %s", payload)
+		}
+	})
+
+	t.Run("Publish location", func(t *testing.T) {
+		tracker.PublishLocation(broker, Location{Latitude: 59.33, Longitude: 18.06, GPSAccuracy: 12})
+		got, _ := broker.last(tracker.GetAttributesTopic())
+		want := `{"latitude":59.33,"longitude":18.06,"gps_accuracy":12}`
+		if got != want {
+			t.Errorf("got %s want %s", got, want)
+		}
+	})
+
+	t.Run("Publish zone", func(t *testing.T) {
+		tracker.PayloadNotHome = "away"
+		tracker.PublishZone(broker, TrackerNotHome)
+		got, _ := broker.last(tracker.GetStateTopic())
+		if got != "away" {
+			t.Errorf("got %s want away", got)
+		}
+	})
+}
+
+func TestImages(t *testing.T) {
+	jpeg := []byte{0xff, 0xd8, 0xff, 0xe0, 0x00, 0x10}
+
+	t.Run("Camera publishes base64 images", func(t *testing.T) {
+		broker := &fakeClient{}
+		device := Device{Ident: "door01"}
+		camera := NewCamera("snapshot")
+		camera.Base64 = true
+		device.AddComponent(&camera)
+		camera.PublishImage(broker, jpeg)
+		got, _ := broker.last(camera.GetImageTopic())
+		if got != "/9j/4AAQ" {
+			t.Errorf("got %s want /9j/4AAQ", got)
+		}
+		payload, _ := camera.GetDiscoverPayload()
+		var discover map[string]interface{}
+		json.Unmarshal(payload, &discover)
+		if discover["t"] != camera.GetImageTopic() || discover["image_encoding"] != "b64" {
+			t.Errorf("got %s", payload)
+		}
+	})
+
+	t.Run("Images larger than max size are rejected", func(t *testing.T) {
+		broker := &fakeClient{}
+		image := NewImage("plant")
+		image.MaxSize = 4
+		if err := image.PublishImage(broker, jpeg); err == nil || len(broker.published) != 0 {
+			t.Errorf("Large image published")
+		}
+	})
+
+	t.Run("Images are throttled", func(t *testing.T) {
+		broker := &fakeClient{}
+		image := NewImage("plant")
+		image.MinInterval = time.Hour
+		image.PublishImage(broker, jpeg)
+		image.PublishImage(broker, jpeg)
+		if len(broker.published) != 1 {
+			t.Errorf("got %d publishes want 1", len(broker.published))
+		}
+	})
+
+	t.Run("Throttled image is published after the interval", func(t *testing.T) {
+		broker := &fakeClient{}
+		camera := NewCamera("doorbell")
+		camera.MinInterval = 20 * time.Millisecond
+		camera.PublishImage(broker, []byte("ring1"))
+		camera.PublishImage(broker, []byte("ring2"))
+		camera.PublishImage(broker, []byte("ring3"))
+		time.Sleep(60 * time.Millisecond)
+		broker.mu.Lock()
+		published := len(broker.published)
+		broker.mu.Unlock()
+		got, _ := broker.last(camera.GetImageTopic())
+		if published != 2 || got != "ring3" {
+			t.Errorf("got %d publishes, last %s want 2, ring3", published, got)
+		}
+	})
+
+	t.Run("Image url discovery", func(t *testing.T) {
+		image := NewImage("plant")
+		image.URL = true
+		payload, _ := image.GetDiscoverPayload()
+		var got map[string]interface{}
+		json.Unmarshal(payload, &got)
+		if got["url_topic"] != "homeassistant/image/plant/url" || got["image_topic"] != nil {
+			t.Errorf("got %s", payload)
+		}
+	})
+}
+
+func TestUpdate(t *testing.T) {
+	broker := &fakeClient{}
+	device := Device{Ident: "device01"}
+	update := NewUpdate("firmware")
+	device.AddComponent(&update)
+	update.SetVersions("1.0.0", "1.1.0")
+	var progress []string
+	update.SubscribeCommand(broker, func(latest string, report func(int)) error {
+		report(50)
+		got, _ := broker.last(update.GetStateTopic())
+		progress = append(progress, got)
+		return nil
+	})
+	installed := func() {
+		for i := 0; i < 100 && update.State().InProgress; i++ {
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	t.Run("Install reports progress and new version", func(t *testing.T) {
+		err := update.HandleCommand(broker, "install")
+		if err != nil {
+			t.Fatalf("Got error but didn't want one: %s", err)
+		}
+		installed()
+		want := `{"installed_version":"1.0.0","latest_version":"1.1.0","in_progress":true,"update_percentage":50}`
+		if len(progress) != 1 || progress[0] != want {
+			t.Errorf("got %v want %s", progress, want)
+		}
+		got, _ := broker.last(update.GetStateTopic())
+		want = `{"installed_version":"1.1.0","latest_version":"1.1.0","in_progress":false,"update_percentage":null}`
+		if got != want {
+			t.Errorf("got %s want %s", got, want)
+		}
+	})
+
+	t.Run("Failed install keeps installed version", func(t *testing.T) {
+		update.SetVersions("1.1.0", "1.2.0")
+		update.SubscribeCommand(broker, func(latest string, report func(int)) error {
+			return errors.New("Flash failed")
+		})
+		update.HandleCommand(broker, "install")
+		installed()
+		if update.State().InstalledVersion != "1.1.0" {
+			t.Errorf("Failed install changed version")
+		}
+	})
+
+	t.Run("Install doesn't block commands", func(t *testing.T) {
+		release := make(chan struct{})
+		update.SubscribeCommand(broker, func(latest string, report func(int)) error {
+			<-release
+			return nil
+		})
+		if err := update.HandleCommand(broker, "install"); err != nil {
+			t.Fatalf("Got error but didn't want one: %s", err)
+		}
+		if err := update.HandleCommand(broker, "install"); err == nil {
+			t.Errorf("Wanted error for install already in progress")
+		}
+		close(release)
+		installed()
+		if update.State().InstalledVersion != "1.2.0" {
+			t.Errorf("got %s want 1.2.0", update.State().InstalledVersion)
+		}
+	})
+
+	t.Run("Install command in discovery", func(t *testing.T) {
+		u := NewUpdate("firmware")
+		payload, _ := u.GetDiscoverPayload()
+		if bytes.Contains(payload, []byte("cmd_t")) {
+			t.Errorf("got %s", payload)
+		}
+		u.Installable = true
+		payload, _ = u.GetDiscoverPayload()
+		var got map[string]interface{}
+		json.Unmarshal(payload, &got)
+		if got["cmd_t"] != u.GetCommandTopic() {
+			t.Errorf("got %s", payload)
+		}
+	})
+}
+
+func TestValve(t *testing.T) {
+	broker := &fakeClient{}
+	valve := NewValve("irrigation")
+	valve.ReportsPosition = true
+	var commands []ValveCommand
+	valve.SubscribeCommand(broker, func(command ValveCommand) error {
+		commands = append(commands, command)
+		return nil
+	})
+
+	t.Run("Position command publishes json state", func(t *testing.T) {
+		err := valve.HandleCommand(broker, "40")
+		if err != nil {
+			t.Fatalf("Got error but didn't want one: %s", err)
+		}
+		got, _ := broker.last(valve.GetStateTopic())
+		want := `{"position":40,"state":"open"}`
+		if got != want {
+			t.Errorf("got %s want %s", got, want)
+		}
+		if len(commands) != 1 || commands[0] != (ValveCommand{Action: ValveActionPosition, Position: 40}) {
+			t.Errorf("got %v", commands)
+		}
+	})
+
+	t.Run("Close command", func(t *testing.T) {
+		valve.HandleCommand(broker, "CLOSE")
+		if valve.State() != ValveClosed || valve.Position() != 0 {
+			t.Errorf("got %s %d want closed 0", valve.State(), valve.Position())
+		}
+	})
+
+	t.Run("Invalid position is rejected", func(t *testing.T) {
+		if err := valve.HandleCommand(broker, "140"); err == nil {
+			t.Errorf("Wanted error but didn't get one")
+		}
+	})
+
+	t.Run("Position mode discovery has no open and close payloads", func(t *testing.T) {
+		payload, _ := valve.GetDiscoverPayload()
+		var got map[string]interface{}
+		json.Unmarshal(payload, &got)
+		if got["pos"] != true || got["pl_open"] != nil || got["pl_cls"] != nil {
+			t.Errorf("got %s", payload)
+		}
+		if err := valve.Validate(); err != nil {
+			t.Errorf("Got error but didn't want one: %s", err)
+		}
+		valve.PayloadOpen = "OPEN"
+		defer func() { valve.PayloadOpen = "" }()
+		if err := valve.Validate(); err == nil {
+			t.Errorf("Wanted error for payload_open with reports_position")
+		}
+	})
+}
+
+func TestHumidifier(t *testing.T) {
+	broker := &fakeClient{}
+	humidifier := NewHumidifier("bedroom")
+	humidifier.Modes = []string{"normal", "sleep"}
+	humidifier.MinHumidity = 30
+	humidifier.MaxHumidity = 80
+	humidifier.SubscribeCommand(broker, func(command HumidifierCommand) error {
+		if command.Type == HumidifierCommandMode && command.Mode == "sleep" {
+			return errors.New("Sleep mode unavailable")
+		}
+		return nil
+	})
+
+	t.Run("Commands update state", func(t *testing.T) {
+		humidifier.HandleCommand(broker, humidifier.GetCommandTopic(), "ON")
+		humidifier.HandleCommand(broker, humidifier.GetTargetHumidityCommandTopic(), "55")
+		humidifier.HandleCommand(broker, humidifier.GetModeCommandTopic(), "normal")
+		if !humidifier.State() || humidifier.TargetHumidity() != 55 || humidifier.Mode() != "normal" {
+			t.Errorf("got %v %.1f %s", humidifier.State(), humidifier.TargetHumidity(), humidifier.Mode())
+		}
+		got, _ := broker.last(humidifier.GetTargetHumidityStateTopic())
+		if got != "55.0" {
+			t.Errorf("got %s want 55.0", got)
+		}
+	})
+
+	t.Run("Rejected commands keep state", func(t *testing.T) {
+		if err := humidifier.HandleCommand(broker, humidifier.GetTargetHumidityCommandTopic(), "90"); err == nil {
+			t.Errorf("Wanted error for out of range humidity")
+		}
+		if err := humidifier.HandleCommand(broker, humidifier.GetModeCommandTopic(), "sleep"); err == nil || humidifier.Mode() != "normal" {
+			t.Errorf("Failed command changed mode to %s", humidifier.Mode())
+		}
+	})
+
+	t.Run("Discovery", func(t *testing.T) {
+		payload, _ := humidifier.GetDiscoverPayload()
+		var discovery map[string]interface{}
+		json.Unmarshal(payload, &discovery)
+		if discovery["mode_command_topic"] != humidifier.GetModeCommandTopic() || discovery["min_humidity"] != 30.0 {
+			t.Errorf("got %s", payload)
+		}
+	})
+}
+
+func TestWaterHeater(t *testing.T) {
+	broker := &fakeClient{}
+	heater := NewWaterHeater("boiler")
+	heater.SubscribeCommand(broker, nil)
+
+	t.Run("Commands update state", func(t *testing.T) {
+		heater.HandleCommand(broker, heater.GetCommandTopic(), WaterHeaterEco)
+		heater.HandleCommand(broker, heater.GetTemperatureCommandTopic(), "50")
+		if got, _ := broker.last(heater.GetStateTopic()); got != WaterHeaterEco {
+			t.Errorf("got %s want %s", got, WaterHeaterEco)
+		}
+		if got, _ := broker.last(heater.GetTemperatureStateTopic()); got != "50.0" {
+			t.Errorf("got %s want 50.0", got)
+		}
+	})
+
+	t.Run("Unsupported mode is rejected", func(t *testing.T) {
+		if err := heater.HandleCommand(broker, heater.GetCommandTopic(), WaterHeaterGas); err == nil {
+			t.Errorf("Wanted error but didn't get one")
+		}
+	})
+
+	t.Run("Validate modes", func(t *testing.T) {
+		heater.Modes = append(heater.Modes, "turbo")
+		if err := heater.Validate(); err == nil {
+			t.Errorf("Wanted validation error for unknown mode")
+		}
+	})
+}
+
+func TestSiren(t *testing.T) {
+	broker := &fakeClient{}
+	siren := NewSiren("alarm")
+	siren.AvailableTones = []string{"fire", "intruder"}
+	siren.SupportDuration = true
+	siren.SupportVolumeSet = true
+	var commands []SirenCommand
+	siren.SubscribeCommand(broker, func(command SirenCommand) error {
+		commands = append(commands, command)
+		return nil
+	})
+
+	t.Run("Json command with tone, volume and duration", func(t *testing.T) {
+		err := siren.HandleCommand(broker, `{"state":"ON","tone":"fire","duration":10,"volume_level":0.5}`)
+		if err != nil {
+			t.Fatalf("Got error but didn't want one: %s", err)
+		}
+		want := SirenCommand{On: true, Tone: "fire", Duration: 10, VolumeLevel: 0.5}
+		if len(commands) != 1 || commands[0] != want {
+			t.Errorf("got %v want %v", commands, want)
+		}
+		got, _ := broker.last(siren.GetStateTopic())
+		if got != `{"state":"ON","tone":"fire","duration":10,"volume_level":0.5}` {
+			t.Errorf("got %s", got)
+		}
+	})
+
+	t.Run("Plain off command", func(t *testing.T) {
+		siren.HandleCommand(broker, "OFF")
+		if siren.State().On {
+			t.Errorf("Siren still on")
+		}
+	})
+
+	t.Run("Omitted fields keep their values", func(t *testing.T) {
+		siren.HandleCommand(broker, `{"state":"ON","tone":"intruder"}`)
+		want := SirenCommand{On: true, Tone: "intruder", Duration: 10, VolumeLevel: 0.5}
+		if siren.State() != want {
+			t.Errorf("got %v want %v", siren.State(), want)
+		}
+	})
+
+	t.Run("Unknown tone is rejected", func(t *testing.T) {
+		if err := siren.HandleCommand(broker, `{"state":"ON","tone":"horn"}`); err == nil {
+			t.Errorf("Wanted error but didn't get one")
+		}
+	})
+}
+
+func TestNotify(t *testing.T) {
+	broker := &fakeClient{}
+	device := Device{Ident: "display01", Name: "Display"}
+	notify := NewNotify("text")
+	device.AddComponent(&notify)
+	var messages []string
+	notify.SubscribeCommand(broker, func(message string) error {
+		messages = append(messages, message)
+		return nil
+	})
+	notify.HandleCommand(broker, "Dinner is ready")
+	if !reflect.DeepEqual(messages, []string{"Dinner is ready"}) {
+		t.Errorf("got %v", messages)
+	}
+	payload, _ := notify.GetDiscoverPayload()
+	var discovery map[string]interface{}
+	json.Unmarshal(payload, &discovery)
+	if discovery["cmd_t"] != "homeassistant/notify/display01_text/command" {
+		t.Errorf("got %s", payload)
+	}
+}
+
+func TestVacuum(t *testing.T) {
+	broker := &fakeClient{}
+	vacuum := NewVacuum("robot")
+	vacuum.FanSpeeds = []string{"quiet", "max"}
+	vacuum.SetBatteryLevel(80)
+	var commands []VacuumCommand
+	vacuum.SubscribeCommand(broker, func(command VacuumCommand) error {
+		commands = append(commands, command)
+		return nil
+	})
+
+	t.Run("Commands update json state", func(t *testing.T) {
+		vacuum.HandleCommand(broker, vacuum.GetCommandTopic(), VacuumStart)
+		vacuum.HandleCommand(broker, vacuum.GetFanSpeedCommandTopic(), "max")
+		got, _ := broker.last(vacuum.GetStateTopic())
+		want := `{"state":"cleaning","battery_level":80,"fan_speed":"max"}`
+		if got != want {
+			t.Errorf("got %s want %s", got, want)
+		}
+		wantCommands := []VacuumCommand{{Command: VacuumStart}, {Command: VacuumSetFanSpeed, FanSpeed: "max"}}
+		if !reflect.DeepEqual(commands, wantCommands) {
+			t.Errorf("got %v want %v", commands, wantCommands)
+		}
+	})
+
+	t.Run("Unknown fan speed is rejected", func(t *testing.T) {
+		if err := vacuum.HandleCommand(broker, vacuum.GetFanSpeedCommandTopic(), "turbo"); err == nil {
+			t.Errorf("Wanted error but didn't get one")
+		}
+	})
+
+	t.Run("Discovery adds fan_speed feature", func(t *testing.T) {
+		payload, _ := vacuum.GetDiscoverPayload()
+		var discovery struct {
+			Features []string `json:"sup_feat"`
+		}
+		json.Unmarshal(payload, &discovery)
+		if discovery.Features[len(discovery.Features)-1] != "fan_speed" || containsString(vacuum.SupportedFeatures, "fan_speed") {
+			t.Errorf("got %s", payload)
+		}
+	})
+
+	t.Run("Features without a command topic are rejected", func(t *testing.T) {
+		v := NewVacuum("robot")
+		v.SupportedFeatures = []string{"start", "send_command"}
+		if v.Validate() == nil {
+			t.Errorf("send_command not rejected")
+		}
+	})
+}
+
+func TestLawnMower(t *testing.T) {
+	broker := &fakeClient{}
+	mower := NewLawnMower("mower")
+	mower.SubscribeCommand(broker, func(command LawnMowerCommand) error {
+		if command == LawnMowerPause {
+			return errors.New("Blade stuck")
+		}
+		return nil
+	})
+
+	mower.HandleCommand(broker, mower.GetActionCommandTopic(LawnMowerStartMowing))
+	if got, _ := broker.last(mower.GetStateTopic()); got != "mowing" {
+		t.Errorf("got %s want mowing", got)
+	}
+	if err := mower.HandleCommand(broker, mower.GetActionCommandTopic(LawnMowerPause)); err == nil || mower.State() != LawnMowerMowing {
+		t.Errorf("Failed command changed activity to %s", mower.State())
+	}
+	mower.HandleCommand(broker, mower.GetActionCommandTopic(LawnMowerDock))
+	if mower.State() != LawnMowerReturning {
+		t.Errorf("got %s want returning", mower.State())
+	}
+}
@@ -0,0 +1,410 @@
+package homeassistant
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	MQTT "github.com/eclipse/paho.mqtt.golang"
+	log "github.com/sirupsen/logrus"
+)
+
+// Humidifier command types
+const (
+	HumidifierCommandPower          = "power"
+	HumidifierCommandTargetHumidity = "target_humidity"
+	HumidifierCommandMode           = "mode"
+)
+
+// HumidifierCommand is a command received from Home Assistant, only the
+// field matching Type is set
+type HumidifierCommand struct {
+	Type           string
+	On             bool
+	TargetHumidity float64
+	Mode           string
+}
+
+// HumidifierCommandFunc switches the humidifier, sets its target humidity or
+// mode, an error keeps the previous setting
+type HumidifierCommandFunc func(command HumidifierCommand) error
+
+// Humidifier HA humidifier or dehumidifier with target humidity and modes
+type Humidifier struct {
+	managed
+	Ident              string
+	Name               string
+	Device             *Device
+	DeviceClass        string
+	Icon               string
+	Modes              []string
+	MinHumidity        float64
+	MaxHumidity        float64
+	Optimistic         bool
+	PayloadOn          string
+	PayloadOff         string
+	EntityAvailability bool
+	Availability       []Availability
+	AvailabilityMode   string
+	on                 bool
+	targetHumidity     float64
+	mode               string
+	currentHumidity    *float64
+	commandFunc        HumidifierCommandFunc
+}
+
+// NewHumidifier creates a new humidifier accepting 0-100% target humidity
+func NewHumidifier(ident string) Humidifier {
+	h := Humidifier{
+		Ident:       ident,
+		DeviceClass: "humidifier",
+		MaxHumidity: 100,
+		PayloadOn:   "ON",
+		PayloadOff:  "OFF",
+	}
+	return h
+}
+
+// State returns true when the humidifier is on
+func (h *Humidifier) State() bool {
+	return h.on
+}
+
+// SetState turns the humidifier on or off
+func (h *Humidifier) SetState(on bool) {
+	h.on = on
+}
+
+// TargetHumidity returns the target humidity
+func (h *Humidifier) TargetHumidity() float64 {
+	return h.targetHumidity
+}
+
+// SetTargetHumidity sets the target humidity
+func (h *Humidifier) SetTargetHumidity(humidity float64) {
+	h.targetHumidity = humidity
+}
+
+// Mode returns the current mode
+func (h *Humidifier) Mode() string {
+	return h.mode
+}
+
+// SetMode sets the current mode
+func (h *Humidifier) SetMode(mode string) {
+	h.mode = mode
+}
+
+// SetCurrentHumidity sets the measured humidity
+func (h *Humidifier) SetCurrentHumidity(humidity float64) {
+	h.currentHumidity = &humidity
+}
+
+func (h *Humidifier) stateString() string {
+	if h.on {
+		return orDefault(h.PayloadOn, "ON")
+	}
+	return orDefault(h.PayloadOff, "OFF")
+}
+
+// PublishState publishes the state, target humidity, mode and measured
+// humidity to broker
+func (h *Humidifier) PublishState(broker MQTT.Client) error {
+	err := publishEncoded(broker, h.GetStateTopic(), false, RawPayload(h.stateString()))
+	if err != nil {
+		return err
+	}
+	err = publishEncoded(broker, h.GetTargetHumidityStateTopic(), false, RawPayload(fmt.Sprintf("%.1f", h.targetHumidity)))
+	if err != nil {
+		return err
+	}
+	if h.mode != "" {
+		err = publishEncoded(broker, h.GetModeStateTopic(), false, RawPayload(h.mode))
+		if err != nil {
+			return err
+		}
+	}
+	if h.currentHumidity != nil {
+		return publishEncoded(broker, h.GetCurrentHumidityTopic(), false, RawPayload(fmt.Sprintf("%.1f", *h.currentHumidity)))
+	}
+	return nil
+}
+
+// GetCommandTopic returns the on/off command topic
+func (h *Humidifier) GetCommandTopic() string {
+	return fmt.Sprintf("%s/command", h.GetBaseTopic())
+}
+
+// GetTargetHumidityStateTopic returns the target humidity state topic
+func (h *Humidifier) GetTargetHumidityStateTopic() string {
+	return fmt.Sprintf("%s/target_humidity/state", h.GetBaseTopic())
+}
+
+// GetTargetHumidityCommandTopic returns the target humidity command topic
+func (h *Humidifier) GetTargetHumidityCommandTopic() string {
+	return fmt.Sprintf("%s/target_humidity/command", h.GetBaseTopic())
+}
+
+// GetModeStateTopic returns the mode state topic
+func (h *Humidifier) GetModeStateTopic() string {
+	return fmt.Sprintf("%s/mode/state", h.GetBaseTopic())
+}
+
+// GetModeCommandTopic returns the mode command topic
+func (h *Humidifier) GetModeCommandTopic() string {
+	return fmt.Sprintf("%s/mode/command", h.GetBaseTopic())
+}
+
+// GetCurrentHumidityTopic returns the measured humidity topic
+func (h *Humidifier) GetCurrentHumidityTopic() string {
+	return fmt.Sprintf("%s/current_humidity", h.GetBaseTopic())
+}
+
+// commandTopics returns the command topics, the mode topic only with modes
+func (h *Humidifier) commandTopics() []string {
+	topics := []string{h.GetCommandTopic(), h.GetTargetHumidityCommandTopic()}
+	if len(h.Modes) > 0 {
+		topics = append(topics, h.GetModeCommandTopic())
+	}
+	return topics
+}
+
+// SubscribeCommand subscribe to the command channels
+func (h *Humidifier) SubscribeCommand(broker MQTT.Client, function HumidifierCommandFunc) error {
+	h.commandFunc = function
+	for _, topic := range h.commandTopics() {
+		token := broker.Subscribe(topic, 0, h.CommandReceived)
+		token.Wait()
+		if token.Error() != nil {
+			return token.Error()
+		}
+	}
+	return nil
+}
+
+// CommandReceived when getting a message from topic
+func (h *Humidifier) CommandReceived(broker MQTT.Client, message MQTT.Message) {
+	err := h.HandleCommand(broker, message.Topic(), string(message.Payload()))
+	if err != nil {
+		log.Warnf("Humidifier %s command failed: %s", h.GetName(), err)
+	}
+}
+
+// HandleCommand applies a command received on topic and publishes the new
+// state if the command function succeeds
+func (h *Humidifier) HandleCommand(broker MQTT.Client, topic string, payload string) error {
+	var command HumidifierCommand
+	switch topic {
+	case h.GetCommandTopic():
+		command.Type = HumidifierCommandPower
+		switch payload {
+		case orDefault(h.PayloadOn, "ON"):
+			command.On = true
+		case orDefault(h.PayloadOff, "OFF"):
+			command.On = false
+		default:
+			return fmt.Errorf("Unknown payload %s", payload)
+		}
+	case h.GetTargetHumidityCommandTopic():
+		humidity, err := strconv.ParseFloat(payload, 64)
+		if err != nil {
+			return fmt.Errorf("Unknown payload %s", payload)
+		}
+		if humidity < h.MinHumidity || humidity > h.MaxHumidity {
+			return fmt.Errorf("Target humidity %.1f out of range %.1f-%.1f", humidity, h.MinHumidity, h.MaxHumidity)
+		}
+		command = HumidifierCommand{Type: HumidifierCommandTargetHumidity, TargetHumidity: humidity}
+	case h.GetModeCommandTopic():
+		if !containsString(h.Modes, payload) {
+			return fmt.Errorf("Unknown mode %s", payload)
+		}
+		command = HumidifierCommand{Type: HumidifierCommandMode, Mode: payload}
+	default:
+		return fmt.Errorf("Unknown command topic %s", topic)
+	}
+	if h.commandFunc != nil {
+		err := h.commandFunc(command)
+		if err != nil {
+			return err
+		}
+	}
+	unlock := lockState(h)
+	defer unlock()
+	switch command.Type {
+	case HumidifierCommandPower:
+		h.on = command.On
+	case HumidifierCommandTargetHumidity:
+		h.targetHumidity = command.TargetHumidity
+	case HumidifierCommandMode:
+		h.mode = command.Mode
+	}
+	return h.PublishState(broker)
+}
+
+// Validate checks the device class, that the humidity range isn't empty and
+// all command topics
+func (h *Humidifier) Validate() error {
+	var v validator
+	v.required("ident", h.Ident)
+	v.oneOf("device_class", h.DeviceClass, []string{"humidifier", "dehumidifier"})
+	if h.MinHumidity >= h.MaxHumidity {
+		v.addf("min_humidity %.1f must be lower than max_humidity %.1f", h.MinHumidity, h.MaxHumidity)
+	}
+	v.topic("state topic", h.GetStateTopic())
+	for _, topic := range h.commandTopics() {
+		v.topic("command topic", topic)
+	}
+	v.topic("discover topic", h.GetDiscoverTopic())
+	v.topic("availability topic", h.GetAvailabilityTopic())
+	v.availability(h.Availability, h.AvailabilityMode)
+	return v.err(h.GetName())
+}
+
+// GetDiscoverPayload generates discover payload json
+func (h *Humidifier) GetDiscoverPayload() ([]byte, error) {
+	availabilityTopic, availability, availabilityMode := discoverAvailability(h, h.GetEntityAvailabilityTopic(), h.EntityAvailability, h.Availability, h.AvailabilityMode)
+	var modeStateTopic, modeCommandTopic string
+	if len(h.Modes) > 0 {
+		modeStateTopic = h.GetModeStateTopic()
+		modeCommandTopic = h.GetModeCommandTopic()
+	}
+	return json.Marshal(&struct {
+		UniqueID                   string         `json:"unique_id"`
+		Name                       string         `json:"name"`
+		StateTopic                 string         `json:"stat_t"`
+		CommandTopic               string         `json:"cmd_t"`
+		TargetHumidityStateTopic   string         `json:"target_humidity_state_topic"`
+		TargetHumidityCommandTopic string         `json:"target_humidity_command_topic"`
+		CurrentHumidityTopic       string         `json:"current_humidity_topic"`
+		ModeStateTopic             string         `json:"mode_state_topic,omitempty"`
+		ModeCommandTopic           string         `json:"mode_command_topic,omitempty"`
+		Modes                      []string       `json:"modes,omitempty"`
+		MinHumidity                float64        `json:"min_humidity"`
+		MaxHumidity                float64        `json:"max_humidity"`
+		Optimistic                 bool           `json:"opt,omitempty"`
+		PayloadOn                  string         `json:"pl_on,omitempty"`
+		PayloadOff                 string         `json:"pl_off,omitempty"`
+		AvailabilityTopic          string         `json:"avty_t,omitempty"`
+		Availability               []Availability `json:"avty,omitempty"`
+		AvailabilityMode           string         `json:"avty_mode,omitempty"`
+		Icon                       string         `json:"icon,omitempty"`
+		DeviceClass                string         `json:"dev_cla,omitempty"`
+		Device                     *Device        `json:"device,omitempty"`
+		Origin                     Origin         `json:"o"`
+	}{
+		UniqueID:                   h.GetIdent(),
+		Name:                       h.GetName(),
+		StateTopic:                 h.GetStateTopic(),
+		CommandTopic:               h.GetCommandTopic(),
+		TargetHumidityStateTopic:   h.GetTargetHumidityStateTopic(),
+		TargetHumidityCommandTopic: h.GetTargetHumidityCommandTopic(),
+		CurrentHumidityTopic:       h.GetCurrentHumidityTopic(),
+		ModeStateTopic:             modeStateTopic,
+		ModeCommandTopic:           modeCommandTopic,
+		Modes:                      h.Modes,
+		MinHumidity:                h.MinHumidity,
+		MaxHumidity:                h.MaxHumidity,
+		Optimistic:                 h.Optimistic,
+		PayloadOn:                  h.PayloadOn,
+		PayloadOff:                 h.PayloadOff,
+		AvailabilityTopic:          availabilityTopic,
+		Availability:               availability,
+		AvailabilityMode:           availabilityMode,
+		Icon:                       h.Icon,
+		DeviceClass:                h.DeviceClass,
+		Device:                     h.Device,
+		Origin:                     componentOrigin(h),
+	})
+}
+
+// GetDevice of humidifier
+func (h *Humidifier) GetDevice() *Device {
+	return h.Device
+}
+
+// SetDevice of humidifier
+func (h *Humidifier) SetDevice(device *Device) {
+	h.Device = device
+}
+
+// GetName of the humidifier
+func (h *Humidifier) GetName() string {
+	var name string
+	if h.Name == "" {
+		name = h.Ident
+	} else {
+		name = h.Name
+	}
+	if h.Device != nil {
+		return fmt.Sprintf("%s %s", h.Device.Name, name)
+	}
+	return name
+}
+
+// GetIdent of the humidifier
+func (h *Humidifier) GetIdent() string {
+	if h.Device == nil {
+		return h.Ident
+	}
+	return fmt.Sprintf("%s_%s", h.Device.Ident, h.Ident)
+}
+
+// GetPlatform returns the Home Assistant platform of the humidifier
+func (h *Humidifier) GetPlatform() string {
+	return "humidifier"
+}
+
+// GetBaseTopic for broker
+func (h *Humidifier) GetBaseTopic() string {
+	return fmt.Sprintf("homeassistant/%s/%s", h.GetPlatform(), h.GetIdent())
+}
+
+// GetStateTopic returns state topic
+func (h *Humidifier) GetStateTopic() string {
+	return fmt.Sprintf("%s/state", h.GetBaseTopic())
+}
+
+// GetAvailabilityTopic returns availability topic
+func (h *Humidifier) GetAvailabilityTopic() string {
+	if h.Device == nil {
+		return fmt.Sprintf("%s/availability", h.GetBaseTopic())
+	}
+	return h.Device.GetAvailabilityTopic()
+}
+
+// GetEntityAvailabilityTopic returns the availability topic of the humidifier itself
+func (h *Humidifier) GetEntityAvailabilityTopic() string {
+	return fmt.Sprintf("%s/availability", h.GetBaseTopic())
+}
+
+// PublishAvailable send humidifier availability message to broker
+func (h *Humidifier) PublishAvailable(broker MQTT.Client) error {
+	return publishAvailability(broker, h.GetName(), h.GetEntityAvailabilityTopic(), payloadAvailable)
+}
+
+// PublishUnavailable send humidifier unavailability message to broker
+func (h *Humidifier) PublishUnavailable(broker MQTT.Client) error {
+	return publishAvailability(broker, h.GetName(), h.GetEntityAvailabilityTopic(), payloadNotAvailable)
+}
+
+// PublishDiscover publish discover payload to MQTT
+func (h *Humidifier) PublishDiscover(broker MQTT.Client) error {
+	err := h.Validate()
+	if err != nil {
+		return err
+	}
+	payload, err := h.GetDiscoverPayload()
+	if err != nil {
+		return err
+	}
+	token := broker.Publish(h.GetDiscoverTopic(), 0, true, payload)
+	log.Infof("Publishing humidifier %s discovery to %s", h.GetName(), h.GetDiscoverTopic())
+	log.Debug(string(payload))
+	token.Wait()
+	return nil
+}
+
+// GetDiscoverTopic returns discover topic
+func (h *Humidifier) GetDiscoverTopic() string {
+	return fmt.Sprintf("%s/config", h.GetBaseTopic())
+}
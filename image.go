@@ -0,0 +1,498 @@
+package homeassistant
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	MQTT "github.com/eclipse/paho.mqtt.golang"
+	log "github.com/sirupsen/logrus"
+)
+
+// DefaultMaxImageSize is the largest image published when MaxSize isn't set
+const DefaultMaxImageSize = 1024 * 1024
+
+// imagePublisher holds what Image and Camera need to publish images
+type imagePublisher struct {
+	MaxSize     int
+	Base64      bool
+	MinInterval time.Duration
+	mu          lazyMutex
+	lastImage   []byte
+	lastPublish time.Time
+	trailing    *time.Timer
+	generation  int
+}
+
+// publishImage publishes data to topic unless it is too large, an image
+// published too soon after the last one is delayed until MinInterval has
+// passed, only the latest delayed image is published
+func (p *imagePublisher) publishImage(broker MQTT.Client, name string, topic string, data []byte) error {
+	maxSize := p.MaxSize
+	if maxSize <= 0 {
+		maxSize = DefaultMaxImageSize
+	}
+	if len(data) > maxSize {
+		return fmt.Errorf("Image of %d bytes is larger than %d bytes", len(data), maxSize)
+	}
+	unlock := p.mu.lock()
+	defer unlock()
+	p.lastImage = data
+	now := time.Now()
+	if since := now.Sub(p.lastPublish); p.MinInterval > 0 && since < p.MinInterval {
+		if p.trailing == nil {
+			log.Debugf("Delaying image of %s, published %s ago", name, since)
+			p.generation++
+			generation := p.generation
+			p.trailing = time.AfterFunc(p.MinInterval-since, func() {
+				p.publishTrailing(broker, name, topic, generation)
+			})
+		}
+		return nil
+	}
+	if p.trailing != nil {
+		p.trailing.Stop()
+		p.trailing = nil
+	}
+	return p.publish(broker, topic, data, now)
+}
+
+// publishTrailing publishes the latest delayed image, unless the delay of
+// generation was superseded by another publish
+func (p *imagePublisher) publishTrailing(broker MQTT.Client, name string, topic string, generation int) {
+	unlock := p.mu.lock()
+	defer unlock()
+	if p.trailing == nil || p.generation != generation {
+		return
+	}
+	p.trailing = nil
+	err := p.publish(broker, topic, p.lastImage, time.Now())
+	if err != nil {
+		log.Warnf("Publishing delayed image of %s failed: %s", name, err)
+	}
+}
+
+// publish sends data to topic, the lock must be held
+func (p *imagePublisher) publish(broker MQTT.Client, topic string, data []byte, now time.Time) error {
+	payload := data
+	if p.Base64 {
+		payload = []byte(base64.StdEncoding.EncodeToString(data))
+	}
+	token := broker.Publish(topic, 0, false, payload)
+	token.Wait()
+	if token.Error() != nil {
+		return token.Error()
+	}
+	p.lastPublish = now
+	return nil
+}
+
+// latestImage returns the last image, nil if there is none
+func (p *imagePublisher) latestImage() []byte {
+	unlock := p.mu.lock()
+	defer unlock()
+	return p.lastImage
+}
+
+// imageEncoding returns the image_encoding of the discover payload
+func (p *imagePublisher) imageEncoding() string {
+	if p.Base64 {
+		return "b64"
+	}
+	return ""
+}
+
+// Image HA image entity publishing images, or urls to images
+type Image struct {
+	imagePublisher
+	managed
+	Ident              string
+	Name               string
+	Device             *Device
+	Icon               string
+	ContentType        string
+	URL                bool
+	EntityAvailability bool
+	Availability       []Availability
+	AvailabilityMode   string
+	lastURL            string
+}
+
+// NewImage creates a new image entity publishing jpeg images
+func NewImage(ident string) Image {
+	i := Image{
+		imagePublisher: imagePublisher{MaxSize: DefaultMaxImageSize},
+		Ident:          ident,
+		ContentType:    "image/jpeg",
+	}
+	return i
+}
+
+// GetImageTopic returns the topic images are published on
+func (i *Image) GetImageTopic() string {
+	return fmt.Sprintf("%s/image", i.GetBaseTopic())
+}
+
+// GetURLTopic returns the topic image urls are published on
+func (i *Image) GetURLTopic() string {
+	return fmt.Sprintf("%s/url", i.GetBaseTopic())
+}
+
+// PublishImage publishes a raw jpeg or png image
+func (i *Image) PublishImage(broker MQTT.Client, data []byte) error {
+	if i.URL {
+		return fmt.Errorf("Image %s publishes urls", i.GetName())
+	}
+	return i.publishImage(broker, i.GetName(), i.GetImageTopic(), data)
+}
+
+// PublishURL publishes the url of an image
+func (i *Image) PublishURL(broker MQTT.Client, url string) error {
+	if !i.URL {
+		return fmt.Errorf("Image %s publishes images", i.GetName())
+	}
+	i.lastURL = url
+	token := broker.Publish(i.GetURLTopic(), 0, false, url)
+	token.Wait()
+	return token.Error()
+}
+
+// PublishState publishes the last image or url again
+func (i *Image) PublishState(broker MQTT.Client) error {
+	if i.URL {
+		if i.lastURL == "" {
+			return nil
+		}
+		return i.PublishURL(broker, i.lastURL)
+	}
+	data := i.latestImage()
+	if data == nil {
+		return nil
+	}
+	return i.PublishImage(broker, data)
+}
+
+// Validate checks the topic the image is published on, and the content type
+// when raw image data is published instead of a url
+func (i *Image) Validate() error {
+	var v validator
+	v.required("ident", i.Ident)
+	if i.URL {
+		v.topic("url topic", i.GetURLTopic())
+	} else {
+		v.topic("image topic", i.GetImageTopic())
+		v.oneOf("content_type", i.ContentType, []string{"image/jpeg", "image/png", "image/gif", "image/webp", "image/svg+xml"})
+	}
+	v.topic("discover topic", i.GetDiscoverTopic())
+	v.topic("availability topic", i.GetAvailabilityTopic())
+	v.availability(i.Availability, i.AvailabilityMode)
+	return v.err(i.GetName())
+}
+
+// GetDiscoverPayload generates discover payload json
+func (i *Image) GetDiscoverPayload() ([]byte, error) {
+	availabilityTopic, availability, availabilityMode := discoverAvailability(i, i.GetEntityAvailabilityTopic(), i.EntityAvailability, i.Availability, i.AvailabilityMode)
+	var imageTopic, urlTopic, contentType, encoding string
+	if i.URL {
+		urlTopic = i.GetURLTopic()
+	} else {
+		imageTopic = i.GetImageTopic()
+		contentType = i.ContentType
+		encoding = i.imageEncoding()
+	}
+	return json.Marshal(&struct {
+		UniqueID          string         `json:"unique_id"`
+		Name              string         `json:"name"`
+		ImageTopic        string         `json:"image_topic,omitempty"`
+		ContentType       string         `json:"content_type,omitempty"`
+		ImageEncoding     string         `json:"image_encoding,omitempty"`
+		URLTopic          string         `json:"url_topic,omitempty"`
+		AvailabilityTopic string         `json:"avty_t,omitempty"`
+		Availability      []Availability `json:"avty,omitempty"`
+		AvailabilityMode  string         `json:"avty_mode,omitempty"`
+		Icon              string         `json:"icon,omitempty"`
+		Device            *Device        `json:"device,omitempty"`
+		Origin            Origin         `json:"o"`
+	}{
+		UniqueID:          i.GetIdent(),
+		Name:              i.GetName(),
+		ImageTopic:        imageTopic,
+		ContentType:       contentType,
+		ImageEncoding:     encoding,
+		URLTopic:          urlTopic,
+		AvailabilityTopic: availabilityTopic,
+		Availability:      availability,
+		AvailabilityMode:  availabilityMode,
+		Icon:              i.Icon,
+		Device:            i.Device,
+		Origin:            componentOrigin(i),
+	})
+}
+
+// GetDevice of image
+func (i *Image) GetDevice() *Device {
+	return i.Device
+}
+
+// SetDevice of image
+func (i *Image) SetDevice(device *Device) {
+	i.Device = device
+}
+
+// GetName of the image
+func (i *Image) GetName() string {
+	var name string
+	if i.Name == "" {
+		name = i.Ident
+	} else {
+		name = i.Name
+	}
+	if i.Device != nil {
+		return fmt.Sprintf("%s %s", i.Device.Name, name)
+	}
+	return name
+}
+
+// GetIdent of the image
+func (i *Image) GetIdent() string {
+	if i.Device == nil {
+		return i.Ident
+	}
+	return fmt.Sprintf("%s_%s", i.Device.Ident, i.Ident)
+}
+
+// GetPlatform returns the Home Assistant platform of the image
+func (i *Image) GetPlatform() string {
+	return "image"
+}
+
+// GetBaseTopic for broker
+func (i *Image) GetBaseTopic() string {
+	return fmt.Sprintf("homeassistant/%s/%s", i.GetPlatform(), i.GetIdent())
+}
+
+// GetStateTopic returns the image or url topic
+func (i *Image) GetStateTopic() string {
+	if i.URL {
+		return i.GetURLTopic()
+	}
+	return i.GetImageTopic()
+}
+
+// GetAvailabilityTopic returns availability topic
+func (i *Image) GetAvailabilityTopic() string {
+	if i.Device == nil {
+		return fmt.Sprintf("%s/availability", i.GetBaseTopic())
+	}
+	return i.Device.GetAvailabilityTopic()
+}
+
+// GetEntityAvailabilityTopic returns the availability topic of the image itself
+func (i *Image) GetEntityAvailabilityTopic() string {
+	return fmt.Sprintf("%s/availability", i.GetBaseTopic())
+}
+
+// PublishAvailable send image availability message to broker
+func (i *Image) PublishAvailable(broker MQTT.Client) error {
+	return publishAvailability(broker, i.GetName(), i.GetEntityAvailabilityTopic(), payloadAvailable)
+}
+
+// PublishUnavailable send image unavailability message to broker
+func (i *Image) PublishUnavailable(broker MQTT.Client) error {
+	return publishAvailability(broker, i.GetName(), i.GetEntityAvailabilityTopic(), payloadNotAvailable)
+}
+
+// PublishDiscover publish discover payload to MQTT
+func (i *Image) PublishDiscover(broker MQTT.Client) error {
+	err := i.Validate()
+	if err != nil {
+		return err
+	}
+	payload, err := i.GetDiscoverPayload()
+	if err != nil {
+		return err
+	}
+	token := broker.Publish(i.GetDiscoverTopic(), 0, true, payload)
+	log.Infof("Publishing image %s discovery to %s", i.GetName(), i.GetDiscoverTopic())
+	log.Debug(string(payload))
+	token.Wait()
+	return nil
+}
+
+// GetDiscoverTopic returns discover topic
+func (i *Image) GetDiscoverTopic() string {
+	return fmt.Sprintf("%s/config", i.GetBaseTopic())
+}
+
+// Camera HA camera entity publishing snapshots
+type Camera struct {
+	imagePublisher
+	managed
+	Ident              string
+	Name               string
+	Device             *Device
+	Icon               string
+	EntityAvailability bool
+	Availability       []Availability
+	AvailabilityMode   string
+}
+
+// NewCamera creates a new camera with default values
+func NewCamera(ident string) Camera {
+	c := Camera{
+		imagePublisher: imagePublisher{MaxSize: DefaultMaxImageSize},
+		Ident:          ident,
+	}
+	return c
+}
+
+// GetImageTopic returns the topic images are published on
+func (c *Camera) GetImageTopic() string {
+	return fmt.Sprintf("%s/image", c.GetBaseTopic())
+}
+
+// PublishImage publishes a raw jpeg or png image
+func (c *Camera) PublishImage(broker MQTT.Client, data []byte) error {
+	return c.publishImage(broker, c.GetName(), c.GetImageTopic(), data)
+}
+
+// PublishState publishes the last image again
+func (c *Camera) PublishState(broker MQTT.Client) error {
+	data := c.latestImage()
+	if data == nil {
+		return nil
+	}
+	return c.PublishImage(broker, data)
+}
+
+// Validate checks the camera ident and the topic frames are published on
+func (c *Camera) Validate() error {
+	var v validator
+	v.required("ident", c.Ident)
+	v.topic("image topic", c.GetImageTopic())
+	v.topic("discover topic", c.GetDiscoverTopic())
+	v.topic("availability topic", c.GetAvailabilityTopic())
+	v.availability(c.Availability, c.AvailabilityMode)
+	return v.err(c.GetName())
+}
+
+// GetDiscoverPayload generates discover payload json
+func (c *Camera) GetDiscoverPayload() ([]byte, error) {
+	availabilityTopic, availability, availabilityMode := discoverAvailability(c, c.GetEntityAvailabilityTopic(), c.EntityAvailability, c.Availability, c.AvailabilityMode)
+	return json.Marshal(&struct {
+		UniqueID          string         `json:"unique_id"`
+		Name              string         `json:"name"`
+		Topic             string         `json:"t"`
+		ImageEncoding     string         `json:"image_encoding,omitempty"`
+		AvailabilityTopic string         `json:"avty_t,omitempty"`
+		Availability      []Availability `json:"avty,omitempty"`
+		AvailabilityMode  string         `json:"avty_mode,omitempty"`
+		Icon              string         `json:"icon,omitempty"`
+		Device            *Device        `json:"device,omitempty"`
+		Origin            Origin         `json:"o"`
+	}{
+		UniqueID:          c.GetIdent(),
+		Name:              c.GetName(),
+		Topic:             c.GetImageTopic(),
+		ImageEncoding:     c.imageEncoding(),
+		AvailabilityTopic: availabilityTopic,
+		Availability:      availability,
+		AvailabilityMode:  availabilityMode,
+		Icon:              c.Icon,
+		Device:            c.Device,
+		Origin:            componentOrigin(c),
+	})
+}
+
+// GetDevice of camera
+func (c *Camera) GetDevice() *Device {
+	return c.Device
+}
+
+// SetDevice of camera
+func (c *Camera) SetDevice(device *Device) {
+	c.Device = device
+}
+
+// GetName of the camera
+func (c *Camera) GetName() string {
+	var name string
+	if c.Name == "" {
+		name = c.Ident
+	} else {
+		name = c.Name
+	}
+	if c.Device != nil {
+		return fmt.Sprintf("%s %s", c.Device.Name, name)
+	}
+	return name
+}
+
+// GetIdent of the camera
+func (c *Camera) GetIdent() string {
+	if c.Device == nil {
+		return c.Ident
+	}
+	return fmt.Sprintf("%s_%s", c.Device.Ident, c.Ident)
+}
+
+// GetPlatform returns the Home Assistant platform of the camera
+func (c *Camera) GetPlatform() string {
+	return "camera"
+}
+
+// GetBaseTopic for broker
+func (c *Camera) GetBaseTopic() string {
+	return fmt.Sprintf("homeassistant/%s/%s", c.GetPlatform(), c.GetIdent())
+}
+
+// GetStateTopic returns the image topic
+func (c *Camera) GetStateTopic() string {
+	return c.GetImageTopic()
+}
+
+// GetAvailabilityTopic returns availability topic
+func (c *Camera) GetAvailabilityTopic() string {
+	if c.Device == nil {
+		return fmt.Sprintf("%s/availability", c.GetBaseTopic())
+	}
+	return c.Device.GetAvailabilityTopic()
+}
+
+// GetEntityAvailabilityTopic returns the availability topic of the camera itself
+func (c *Camera) GetEntityAvailabilityTopic() string {
+	return fmt.Sprintf("%s/availability", c.GetBaseTopic())
+}
+
+// PublishAvailable send camera availability message to broker
+func (c *Camera) PublishAvailable(broker MQTT.Client) error {
+	return publishAvailability(broker, c.GetName(), c.GetEntityAvailabilityTopic(), payloadAvailable)
+}
+
+// PublishUnavailable send camera unavailability message to broker
+func (c *Camera) PublishUnavailable(broker MQTT.Client) error {
+	return publishAvailability(broker, c.GetName(), c.GetEntityAvailabilityTopic(), payloadNotAvailable)
+}
+
+// PublishDiscover publish discover payload to MQTT
+func (c *Camera) PublishDiscover(broker MQTT.Client) error {
+	err := c.Validate()
+	if err != nil {
+		return err
+	}
+	payload, err := c.GetDiscoverPayload()
+	if err != nil {
+		return err
+	}
+	token := broker.Publish(c.GetDiscoverTopic(), 0, true, payload)
+	log.Infof("Publishing camera %s discovery to %s", c.GetName(), c.GetDiscoverTopic())
+	log.Debug(string(payload))
+	token.Wait()
+	return nil
+}
+
+// GetDiscoverTopic returns discover topic
+func (c *Camera) GetDiscoverTopic() string {
+	return fmt.Sprintf("%s/config", c.GetBaseTopic())
+}
@@ -0,0 +1,268 @@
+package homeassistant
+
+import (
+	"encoding/json"
+	"fmt"
+
+	MQTT "github.com/eclipse/paho.mqtt.golang"
+	log "github.com/sirupsen/logrus"
+)
+
+// LawnMowerActivity is the activity state of a LawnMower
+type LawnMowerActivity string
+
+// Lawn mower activities
+const (
+	LawnMowerMowing    LawnMowerActivity = "mowing"
+	LawnMowerPaused    LawnMowerActivity = "paused"
+	LawnMowerDocked    LawnMowerActivity = "docked"
+	LawnMowerReturning LawnMowerActivity = "returning"
+	LawnMowerError     LawnMowerActivity = "error"
+)
+
+// LawnMowerCommand is a command received from Home Assistant
+type LawnMowerCommand string
+
+// Lawn mower commands
+const (
+	LawnMowerStartMowing LawnMowerCommand = "start_mowing"
+	LawnMowerPause       LawnMowerCommand = "pause"
+	LawnMowerDock        LawnMowerCommand = "dock"
+)
+
+// LawnMowerCommandFunc starts, pauses or docks the mower, an error keeps the
+// current activity
+type LawnMowerCommandFunc func(command LawnMowerCommand) error
+
+// LawnMower HA lawn mower robot
+type LawnMower struct {
+	managed
+	Ident              string
+	Name               string
+	Device             *Device
+	Icon               string
+	Optimistic         bool
+	EntityAvailability bool
+	Availability       []Availability
+	AvailabilityMode   string
+	activity           LawnMowerActivity
+	commandFunc        LawnMowerCommandFunc
+}
+
+// NewLawnMower creates a new docked lawn mower
+func NewLawnMower(ident string) LawnMower {
+	m := LawnMower{
+		Ident:    ident,
+		activity: LawnMowerDocked,
+	}
+	return m
+}
+
+// State returns the current activity
+func (m *LawnMower) State() LawnMowerActivity {
+	return m.activity
+}
+
+// SetState sets the current activity
+func (m *LawnMower) SetState(activity LawnMowerActivity) {
+	m.activity = activity
+}
+
+// PublishState publishes the activity to broker
+func (m *LawnMower) PublishState(broker MQTT.Client) error {
+	return publishEncoded(broker, m.GetStateTopic(), false, RawPayload(m.activity))
+}
+
+// GetActionCommandTopic returns the topic of the action command
+func (m *LawnMower) GetActionCommandTopic(command LawnMowerCommand) string {
+	return fmt.Sprintf("%s/%s", m.GetBaseTopic(), command)
+}
+
+// SubscribeCommand subscribe to the start mowing, pause and dock channels
+func (m *LawnMower) SubscribeCommand(broker MQTT.Client, function LawnMowerCommandFunc) error {
+	m.commandFunc = function
+	for _, command := range []LawnMowerCommand{LawnMowerStartMowing, LawnMowerPause, LawnMowerDock} {
+		token := broker.Subscribe(m.GetActionCommandTopic(command), 0, m.CommandReceived)
+		token.Wait()
+		if token.Error() != nil {
+			return token.Error()
+		}
+	}
+	return nil
+}
+
+// CommandReceived when getting a message from topic
+func (m *LawnMower) CommandReceived(broker MQTT.Client, message MQTT.Message) {
+	err := m.HandleCommand(broker, message.Topic())
+	if err != nil {
+		log.Warnf("Lawn mower %s command failed: %s", m.GetName(), err)
+	}
+}
+
+// HandleCommand applies the command of topic and publishes the new activity
+// if the command function succeeds, the payload is ignored
+func (m *LawnMower) HandleCommand(broker MQTT.Client, topic string) error {
+	var command LawnMowerCommand
+	var activity LawnMowerActivity
+	switch topic {
+	case m.GetActionCommandTopic(LawnMowerStartMowing):
+		command, activity = LawnMowerStartMowing, LawnMowerMowing
+	case m.GetActionCommandTopic(LawnMowerPause):
+		command, activity = LawnMowerPause, LawnMowerPaused
+	case m.GetActionCommandTopic(LawnMowerDock):
+		command, activity = LawnMowerDock, LawnMowerReturning
+	default:
+		return fmt.Errorf("Unknown command topic %s", topic)
+	}
+	if m.commandFunc != nil {
+		err := m.commandFunc(command)
+		if err != nil {
+			return err
+		}
+	}
+	unlock := lockState(m)
+	defer unlock()
+	m.SetState(activity)
+	return m.PublishState(broker)
+}
+
+// Validate checks the mower ident, its activity topic and the topics of the
+// start, pause and dock actions
+func (m *LawnMower) Validate() error {
+	var v validator
+	v.required("ident", m.Ident)
+	v.topic("activity state topic", m.GetStateTopic())
+	v.topic("start mowing command topic", m.GetActionCommandTopic(LawnMowerStartMowing))
+	v.topic("pause command topic", m.GetActionCommandTopic(LawnMowerPause))
+	v.topic("dock command topic", m.GetActionCommandTopic(LawnMowerDock))
+	v.topic("discover topic", m.GetDiscoverTopic())
+	v.topic("availability topic", m.GetAvailabilityTopic())
+	v.availability(m.Availability, m.AvailabilityMode)
+	return v.err(m.GetName())
+}
+
+// GetDiscoverPayload generates discover payload json
+func (m *LawnMower) GetDiscoverPayload() ([]byte, error) {
+	availabilityTopic, availability, availabilityMode := discoverAvailability(m, m.GetEntityAvailabilityTopic(), m.EntityAvailability, m.Availability, m.AvailabilityMode)
+	return json.Marshal(&struct {
+		UniqueID                string         `json:"unique_id"`
+		Name                    string         `json:"name"`
+		ActivityStateTopic      string         `json:"activity_state_topic"`
+		StartMowingCommandTopic string         `json:"start_mowing_command_topic"`
+		PauseCommandTopic       string         `json:"pause_command_topic"`
+		DockCommandTopic        string         `json:"dock_command_topic"`
+		Optimistic              bool           `json:"opt,omitempty"`
+		AvailabilityTopic       string         `json:"avty_t,omitempty"`
+		Availability            []Availability `json:"avty,omitempty"`
+		AvailabilityMode        string         `json:"avty_mode,omitempty"`
+		Icon                    string         `json:"icon,omitempty"`
+		Device                  *Device        `json:"device,omitempty"`
+		Origin                  Origin         `json:"o"`
+	}{
+		UniqueID:                m.GetIdent(),
+		Name:                    m.GetName(),
+		ActivityStateTopic:      m.GetStateTopic(),
+		StartMowingCommandTopic: m.GetActionCommandTopic(LawnMowerStartMowing),
+		PauseCommandTopic:       m.GetActionCommandTopic(LawnMowerPause),
+		DockCommandTopic:        m.GetActionCommandTopic(LawnMowerDock),
+		Optimistic:              m.Optimistic,
+		AvailabilityTopic:       availabilityTopic,
+		Availability:            availability,
+		AvailabilityMode:        availabilityMode,
+		Icon:                    m.Icon,
+		Device:                  m.Device,
+		Origin:                  componentOrigin(m),
+	})
+}
+
+// GetDevice of lawn mower
+func (m *LawnMower) GetDevice() *Device {
+	return m.Device
+}
+
+// SetDevice of lawn mower
+func (m *LawnMower) SetDevice(device *Device) {
+	m.Device = device
+}
+
+// GetName of the lawn mower
+func (m *LawnMower) GetName() string {
+	var name string
+	if m.Name == "" {
+		name = m.Ident
+	} else {
+		name = m.Name
+	}
+	if m.Device != nil {
+		return fmt.Sprintf("%s %s", m.Device.Name, name)
+	}
+	return name
+}
+
+// GetIdent of the lawn mower
+func (m *LawnMower) GetIdent() string {
+	if m.Device == nil {
+		return m.Ident
+	}
+	return fmt.Sprintf("%s_%s", m.Device.Ident, m.Ident)
+}
+
+// GetPlatform returns the Home Assistant platform of the lawn mower
+func (m *LawnMower) GetPlatform() string {
+	return "lawn_mower"
+}
+
+// GetBaseTopic for broker
+func (m *LawnMower) GetBaseTopic() string {
+	return fmt.Sprintf("homeassistant/%s/%s", m.GetPlatform(), m.GetIdent())
+}
+
+// GetStateTopic returns state topic
+func (m *LawnMower) GetStateTopic() string {
+	return fmt.Sprintf("%s/state", m.GetBaseTopic())
+}
+
+// GetAvailabilityTopic returns availability topic
+func (m *LawnMower) GetAvailabilityTopic() string {
+	if m.Device == nil {
+		return fmt.Sprintf("%s/availability", m.GetBaseTopic())
+	}
+	return m.Device.GetAvailabilityTopic()
+}
+
+// GetEntityAvailabilityTopic returns the availability topic of the lawn mower itself
+func (m *LawnMower) GetEntityAvailabilityTopic() string {
+	return fmt.Sprintf("%s/availability", m.GetBaseTopic())
+}
+
+// PublishAvailable send lawn mower availability message to broker
+func (m *LawnMower) PublishAvailable(broker MQTT.Client) error {
+	return publishAvailability(broker, m.GetName(), m.GetEntityAvailabilityTopic(), payloadAvailable)
+}
+
+// PublishUnavailable send lawn mower unavailability message to broker
+func (m *LawnMower) PublishUnavailable(broker MQTT.Client) error {
+	return publishAvailability(broker, m.GetName(), m.GetEntityAvailabilityTopic(), payloadNotAvailable)
+}
+
+// PublishDiscover publish discover payload to MQTT
+func (m *LawnMower) PublishDiscover(broker MQTT.Client) error {
+	err := m.Validate()
+	if err != nil {
+		return err
+	}
+	payload, err := m.GetDiscoverPayload()
+	if err != nil {
+		return err
+	}
+	token := broker.Publish(m.GetDiscoverTopic(), 0, true, payload)
+	log.Infof("Publishing lawn mower %s discovery to %s", m.GetName(), m.GetDiscoverTopic())
+	log.Debug(string(payload))
+	token.Wait()
+	return nil
+}
+
+// GetDiscoverTopic returns discover topic
+func (m *LawnMower) GetDiscoverTopic() string {
+	return fmt.Sprintf("%s/config", m.GetBaseTopic())
+}
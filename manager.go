@@ -0,0 +1,436 @@
+package homeassistant
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	MQTT "github.com/eclipse/paho.mqtt.golang"
+	log "github.com/sirupsen/logrus"
+)
+
+// Manager keeps track of devices on a broker and polls their sources
+type Manager struct {
+	Ident         string
+	Client        MQTT.Client
+	Devices       []*Device
+	Components    []Component
+	Store         Store
+	StoreInterval time.Duration
+	Origin        *Origin
+	mu            sync.Mutex
+	logger        *log.Entry
+}
+
+// managed links a component without device to its Manager
+type managed struct {
+	manager *Manager
+}
+
+func (m *managed) setManager(manager *Manager) {
+	m.manager = manager
+}
+
+func (m *managed) getManager() *Manager {
+	return m.manager
+}
+
+// managedComponent is implemented by components embedding managed
+type managedComponent interface {
+	setManager(*Manager)
+	getManager() *Manager
+}
+
+// componentManager returns the manager of c, through its device when it has
+// one, nil when c isn't added to a manager
+func componentManager(c Component) *Manager {
+	if device := c.GetDevice(); device != nil {
+		return device.manager
+	}
+	if m, ok := c.(managedComponent); ok {
+		return m.getManager()
+	}
+	return nil
+}
+
+// lockState locks the manager of c, if any, so a command changing the state
+// of c doesn't race with polling or saving states, call the returned function
+// to unlock
+func lockState(c Component) func() {
+	m := componentManager(c)
+	if m == nil {
+		return func() {}
+	}
+	m.mu.Lock()
+	return m.mu.Unlock
+}
+
+// poller reads a source and updates the state of its component
+type poller struct {
+	component Component
+	config    PollConfig
+	read      func(ctx context.Context) error
+}
+
+// NewManager returns a new manager for client
+func NewManager(client MQTT.Client) *Manager {
+	return &Manager{
+		Client: client,
+		logger: log.WithFields(log.Fields{"unit": "manager"}),
+	}
+}
+
+// NewBridgeManager returns a new manager with ident connecting through b, the
+// LWT of b defaults to the bridge availability topic so all entities become
+// unavailable if the connection is lost. When Client is replaced by a
+// BufferedClient the queued messages are replayed on every connect
+func NewBridgeManager(ident string, b *Broker) *Manager {
+	m := NewManager(nil)
+	m.Ident = ident
+	if b.WillTopic == "" {
+		b.WillTopic = m.GetAvailabilityTopic()
+		b.WillMessage = payloadNotAvailable
+	}
+	onConnect := b.OnConnect
+	b.OnConnect = func(client MQTT.Client) {
+		err := m.PublishAvailable()
+		if err != nil {
+			m.logger.Warnf("Publishing bridge availability failed: %s", err)
+		}
+		if buffered, ok := m.Client.(*BufferedClient); ok {
+			err = buffered.Replay()
+			if err != nil {
+				m.logger.Warnf("Replaying queued messages failed: %s", err)
+			}
+		}
+		if onConnect != nil {
+			onConnect(client)
+		}
+	}
+	m.Client = NewBroker(b)
+	return m
+}
+
+// GetAvailabilityTopic returns the bridge availability topic, empty without ident
+func (m *Manager) GetAvailabilityTopic() string {
+	if m.Ident == "" {
+		return ""
+	}
+	return fmt.Sprintf("bridge/%s/availability", m.Ident)
+}
+
+// PublishAvailable send bridge availability message to broker
+func (m *Manager) PublishAvailable() error {
+	if m.Ident == "" {
+		return nil
+	}
+	return publishAvailability(m.Client, m.Ident, m.GetAvailabilityTopic(), payloadAvailable)
+}
+
+// PublishUnavailable send bridge unavailability message to broker
+func (m *Manager) PublishUnavailable() error {
+	if m.Ident == "" {
+		return nil
+	}
+	return publishAvailability(m.Client, m.Ident, m.GetAvailabilityTopic(), payloadNotAvailable)
+}
+
+// Disconnect marks the bridge unavailable and disconnects from the broker
+func (m *Manager) Disconnect() {
+	err := m.PublishUnavailable()
+	if err != nil {
+		m.logger.Warnf("Publishing bridge unavailability failed: %s", err)
+	}
+	m.Client.Disconnect(250)
+}
+
+// AddComponent without device to the manager, like the components of devices
+// it includes the bridge availability topic and the origin of the manager in
+// its discover payload
+func (m *Manager) AddComponent(component Component) error {
+	if component.GetDevice() != nil {
+		return fmt.Errorf("Component %s belongs to device %s", component.GetIdent(), component.GetDevice().Ident)
+	}
+	for _, c := range m.Components {
+		if c.GetIdent() == component.GetIdent() {
+			return fmt.Errorf("Component already added with ident %s", component.GetIdent())
+		}
+	}
+	if derived, ok := component.(*DerivedSensor); ok {
+		derived.attach()
+	}
+	if c, ok := component.(managedComponent); ok {
+		c.setManager(m)
+	}
+	m.Components = append(m.Components, component)
+	return nil
+}
+
+// components returns the components of all devices and the components
+// without device
+func (m *Manager) components() []Component {
+	var components []Component
+	for _, d := range m.Devices {
+		components = append(components, d.Components...)
+	}
+	return append(components, m.Components...)
+}
+
+// PublishDiscover publishes the discover payloads of all devices and
+// components without device
+func (m *Manager) PublishDiscover() error {
+	for _, d := range m.Devices {
+		err := d.PublishDiscover(m.Client)
+		if err != nil {
+			return err
+		}
+	}
+	for _, c := range m.Components {
+		err := c.PublishDiscover(m.Client)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AddDevice to the manager, the components of the device include the bridge
+// availability topic and the origin of the manager in their discover payloads
+func (m *Manager) AddDevice(device *Device) error {
+	for _, d := range m.Devices {
+		if d.Ident == device.Ident {
+			return fmt.Errorf("Device already added with ident %s", device.Ident)
+		}
+	}
+	device.manager = m
+	m.Devices = append(m.Devices, device)
+	return nil
+}
+
+// Run polls the sources of all components until ctx is done, with a Store
+// the states are saved every StoreInterval and when ctx is done
+func (m *Manager) Run(ctx context.Context) error {
+	var wg sync.WaitGroup
+	if m.Store != nil {
+		interval := m.StoreInterval
+		if interval <= 0 {
+			interval = time.Minute
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			m.flushPeriodically(ctx, interval)
+		}()
+	}
+	for _, p := range m.pollers() {
+		wg.Add(1)
+		go func(p poller) {
+			defer wg.Done()
+			m.poll(ctx, p)
+		}(p)
+	}
+	wg.Wait()
+	return ctx.Err()
+}
+
+// LoadStates loads the state of all components from the Store
+func (m *Manager) LoadStates() error {
+	if m.Store == nil {
+		return errors.New("Manager has no store")
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, c := range m.components() {
+		if p, ok := c.(persistent); ok {
+			_, err := p.LoadState(m.Store)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// SaveStates saves the state of all components to the Store and flushes it
+func (m *Manager) SaveStates() error {
+	if m.Store == nil {
+		return errors.New("Manager has no store")
+	}
+	m.mu.Lock()
+	for _, c := range m.components() {
+		if p, ok := c.(persistent); ok {
+			err := p.SaveState(m.Store)
+			if err != nil {
+				m.mu.Unlock()
+				return err
+			}
+		}
+	}
+	m.mu.Unlock()
+	return m.Store.Flush()
+}
+
+// flushPeriodically saves all states every interval until ctx is done, and
+// once more when it is
+func (m *Manager) flushPeriodically(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			m.saveStates()
+			return
+		case <-ticker.C:
+			m.saveStates()
+		}
+	}
+}
+
+func (m *Manager) saveStates() {
+	err := m.SaveStates()
+	if err != nil {
+		m.logger.Warnf("Saving states failed: %s", err)
+	}
+}
+
+// stateRestorer is implemented by components restoring their state from the broker
+type stateRestorer interface {
+	RestoreState(MQTT.Client, time.Duration) error
+}
+
+// RestoreStates restores the retained state of all components supporting it,
+// waiting at most timeout for each
+func (m *Manager) RestoreStates(timeout time.Duration) {
+	for _, c := range m.components() {
+		if r, ok := c.(stateRestorer); ok {
+			err := r.RestoreState(m.Client, timeout)
+			if err != nil {
+				m.logger.Warnf("Restoring %s state failed: %s", c.GetName(), err)
+			}
+		}
+	}
+}
+
+// pollers returns a poller for every component with a source
+func (m *Manager) pollers() []poller {
+	var pollers []poller
+	for _, c := range m.components() {
+		switch s := c.(type) {
+		case *Sensor:
+			if s.Source != nil {
+				pollers = append(pollers, m.sensorPoller(s))
+			}
+		case *DerivedSensor:
+			if s.Source != nil {
+				pollers = append(pollers, m.sensorPoller(&s.Sensor))
+			}
+		case *BinarySensor:
+			if s.Source != nil {
+				pollers = append(pollers, m.binarySensorPoller(s))
+			}
+		}
+	}
+	return pollers
+}
+
+func (m *Manager) sensorPoller(s *Sensor) poller {
+	return poller{
+		component: s,
+		config:    s.Poll,
+		read: func(ctx context.Context) error {
+			state, err := s.Source.Read(ctx)
+			if err != nil {
+				return err
+			}
+			m.mu.Lock()
+			defer m.mu.Unlock()
+			err = s.AddState(state)
+			if err != nil {
+				m.logger.Warnf("Sensor %s rejected state %.2f: %s", s.GetName(), state, err)
+				return nil
+			}
+			return s.PublishState(m.Client)
+		},
+	}
+}
+
+func (m *Manager) binarySensorPoller(s *BinarySensor) poller {
+	return poller{
+		component: s,
+		config:    s.Poll,
+		read: func(ctx context.Context) error {
+			state, err := s.Source.Read(ctx)
+			if err != nil {
+				return err
+			}
+			m.mu.Lock()
+			defer m.mu.Unlock()
+			s.SetState(state)
+			return s.PublishState(m.Client)
+		},
+	}
+}
+
+// poll reads the source of p at its interval until ctx is done, the
+// component is marked available first as its discover payload requires
+func (m *Manager) poll(ctx context.Context, p poller) {
+	m.setAvailable(p.component, true)
+	failures := 0
+	for {
+		readCtx, cancel := context.WithTimeout(ctx, p.config.timeout())
+		err := p.read(readCtx)
+		cancel()
+		if ctx.Err() != nil {
+			return
+		}
+
+		delay := p.config.interval()
+		if err != nil {
+			failures++
+			m.logger.Warnf("Reading %s failed %d times: %s", p.component.GetName(), failures, err)
+			if failures == p.config.maxFailures() {
+				m.setAvailable(p.component, false)
+			}
+			delay = p.config.backoff(failures)
+		} else {
+			if failures >= p.config.maxFailures() {
+				m.setAvailable(p.component, true)
+			}
+			failures = 0
+		}
+		if p.config.Jitter > 0 {
+			delay += time.Duration(rand.Int63n(int64(p.config.Jitter)))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+	}
+}
+
+// setAvailable publishes the availability of component, using the entity
+// availability topic when the component has one
+func (m *Manager) setAvailable(component Component, available bool) {
+	var err error
+	if p, ok := component.(availabilityPublisher); ok {
+		if available {
+			err = p.PublishAvailable(m.Client)
+		} else {
+			err = p.PublishUnavailable(m.Client)
+		}
+	} else {
+		payload := payloadNotAvailable
+		if available {
+			payload = payloadAvailable
+		}
+		err = publishAvailability(m.Client, component.GetName(), component.GetAvailabilityTopic(), payload)
+	}
+	if err != nil {
+		m.logger.Warnf("Publishing %s availability failed: %s", component.GetName(), err)
+	}
+}
@@ -13,6 +13,7 @@ type Broker struct {
 	Password    string
 	WillTopic   string
 	WillMessage string
+	OnConnect   MQTT.OnConnectHandler
 	client      *MQTT.Client
 	opts        *MQTT.ClientOptions
 	logger      *log.Entry
@@ -33,6 +34,9 @@ func NewBroker(b *Broker) MQTT.Client {
 		b.logger.Debugf("Adding LWT to %s with payload %s", b.WillTopic, b.WillMessage)
 		opts.SetBinaryWill(b.WillTopic, []byte(b.WillMessage), 0, true)
 	}
+	if b.OnConnect != nil {
+		opts.SetOnConnectHandler(b.OnConnect)
+	}
 	b.opts = opts
 	client := MQTT.NewClient(opts)
 	return client
@@ -0,0 +1,199 @@
+package homeassistant
+
+import (
+	"encoding/json"
+	"fmt"
+
+	MQTT "github.com/eclipse/paho.mqtt.golang"
+	log "github.com/sirupsen/logrus"
+)
+
+// NotifyFunc shows the notification message, for example on a display
+type NotifyFunc func(message string) error
+
+// Notify HA notify entity receiving notification messages
+type Notify struct {
+	managed
+	Ident              string
+	Name               string
+	Device             *Device
+	Icon               string
+	CommandTemplate    string
+	EntityAvailability bool
+	Availability       []Availability
+	AvailabilityMode   string
+	notifyFunc         NotifyFunc
+}
+
+// NewNotify creates a new notify entity
+func NewNotify(ident string) Notify {
+	n := Notify{
+		Ident: ident,
+	}
+	return n
+}
+
+// PublishState does nothing, notify entities have no state
+func (n *Notify) PublishState(broker MQTT.Client) error {
+	return nil
+}
+
+// GetCommandTopic returns the topic messages are received on
+func (n *Notify) GetCommandTopic() string {
+	return fmt.Sprintf("%s/command", n.GetBaseTopic())
+}
+
+// SubscribeCommand subscribe to notification messages
+func (n *Notify) SubscribeCommand(broker MQTT.Client, function NotifyFunc) error {
+	n.notifyFunc = function
+	token := broker.Subscribe(n.GetCommandTopic(), 0, n.CommandReceived)
+	token.Wait()
+	return token.Error()
+}
+
+// CommandReceived when getting a message from topic
+func (n *Notify) CommandReceived(broker MQTT.Client, message MQTT.Message) {
+	err := n.HandleCommand(broker, string(message.Payload()))
+	if err != nil {
+		log.Warnf("Notify %s failed: %s", n.GetName(), err)
+	}
+}
+
+// HandleCommand hands the message to the notify function
+func (n *Notify) HandleCommand(broker MQTT.Client, payload string) error {
+	if n.notifyFunc == nil {
+		return nil
+	}
+	return n.notifyFunc(payload)
+}
+
+// Validate checks the notify ident and the topic messages are received on
+func (n *Notify) Validate() error {
+	var v validator
+	v.required("ident", n.Ident)
+	v.topic("command topic", n.GetCommandTopic())
+	v.topic("discover topic", n.GetDiscoverTopic())
+	v.topic("availability topic", n.GetAvailabilityTopic())
+	v.availability(n.Availability, n.AvailabilityMode)
+	return v.err(n.GetName())
+}
+
+// GetDiscoverPayload generates discover payload json
+func (n *Notify) GetDiscoverPayload() ([]byte, error) {
+	availabilityTopic, availability, availabilityMode := discoverAvailability(n, n.GetEntityAvailabilityTopic(), n.EntityAvailability, n.Availability, n.AvailabilityMode)
+	return json.Marshal(&struct {
+		UniqueID          string         `json:"unique_id"`
+		Name              string         `json:"name"`
+		CommandTopic      string         `json:"cmd_t"`
+		CommandTemplate   string         `json:"cmd_tpl,omitempty"`
+		AvailabilityTopic string         `json:"avty_t,omitempty"`
+		Availability      []Availability `json:"avty,omitempty"`
+		AvailabilityMode  string         `json:"avty_mode,omitempty"`
+		Icon              string         `json:"icon,omitempty"`
+		Device            *Device        `json:"device,omitempty"`
+		Origin            Origin         `json:"o"`
+	}{
+		UniqueID:          n.GetIdent(),
+		Name:              n.GetName(),
+		CommandTopic:      n.GetCommandTopic(),
+		CommandTemplate:   n.CommandTemplate,
+		AvailabilityTopic: availabilityTopic,
+		Availability:      availability,
+		AvailabilityMode:  availabilityMode,
+		Icon:              n.Icon,
+		Device:            n.Device,
+		Origin:            componentOrigin(n),
+	})
+}
+
+// GetDevice of notify
+func (n *Notify) GetDevice() *Device {
+	return n.Device
+}
+
+// SetDevice of notify
+func (n *Notify) SetDevice(device *Device) {
+	n.Device = device
+}
+
+// GetName of the notify
+func (n *Notify) GetName() string {
+	var name string
+	if n.Name == "" {
+		name = n.Ident
+	} else {
+		name = n.Name
+	}
+	if n.Device != nil {
+		return fmt.Sprintf("%s %s", n.Device.Name, name)
+	}
+	return name
+}
+
+// GetIdent of the notify
+func (n *Notify) GetIdent() string {
+	if n.Device == nil {
+		return n.Ident
+	}
+	return fmt.Sprintf("%s_%s", n.Device.Ident, n.Ident)
+}
+
+// GetPlatform returns the Home Assistant platform of the notify
+func (n *Notify) GetPlatform() string {
+	return "notify"
+}
+
+// GetBaseTopic for broker
+func (n *Notify) GetBaseTopic() string {
+	return fmt.Sprintf("homeassistant/%s/%s", n.GetPlatform(), n.GetIdent())
+}
+
+// GetStateTopic returns state topic, notify entities never publish to it
+func (n *Notify) GetStateTopic() string {
+	return fmt.Sprintf("%s/state", n.GetBaseTopic())
+}
+
+// GetAvailabilityTopic returns availability topic
+func (n *Notify) GetAvailabilityTopic() string {
+	if n.Device == nil {
+		return fmt.Sprintf("%s/availability", n.GetBaseTopic())
+	}
+	return n.Device.GetAvailabilityTopic()
+}
+
+// GetEntityAvailabilityTopic returns the availability topic of the notify itself
+func (n *Notify) GetEntityAvailabilityTopic() string {
+	return fmt.Sprintf("%s/availability", n.GetBaseTopic())
+}
+
+// PublishAvailable send notify availability message to broker
+func (n *Notify) PublishAvailable(broker MQTT.Client) error {
+	return publishAvailability(broker, n.GetName(), n.GetEntityAvailabilityTopic(), payloadAvailable)
+}
+
+// PublishUnavailable send notify unavailability message to broker
+func (n *Notify) PublishUnavailable(broker MQTT.Client) error {
+	return publishAvailability(broker, n.GetName(), n.GetEntityAvailabilityTopic(), payloadNotAvailable)
+}
+
+// PublishDiscover publish discover payload to MQTT
+func (n *Notify) PublishDiscover(broker MQTT.Client) error {
+	err := n.Validate()
+	if err != nil {
+		return err
+	}
+	payload, err := n.GetDiscoverPayload()
+	if err != nil {
+		return err
+	}
+	token := broker.Publish(n.GetDiscoverTopic(), 0, true, payload)
+	log.Infof("Publishing notify %s discovery to %s", n.GetName(), n.GetDiscoverTopic())
+	log.Debug(string(payload))
+	token.Wait()
+	return nil
+}
+
+// GetDiscoverTopic returns discover topic
+func (n *Notify) GetDiscoverTopic() string {
+	return fmt.Sprintf("%s/config", n.GetBaseTopic())
+}
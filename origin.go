@@ -0,0 +1,48 @@
+package homeassistant
+
+import "runtime/debug"
+
+// Origin of discovered entities shown in Home Assistant
+type Origin struct {
+	Name       string `json:"name"`
+	SWVersion  string `json:"sw,omitempty"`
+	SupportURL string `json:"url,omitempty"`
+}
+
+const modulePath = "github.com/smgt/homeassistant-go"
+
+// DefaultOrigin is used when no origin is configured
+var DefaultOrigin = Origin{
+	Name:       "homeassistant-go",
+	SWVersion:  moduleVersion(),
+	SupportURL: "https://" + modulePath,
+}
+
+// moduleVersion returns the version of this library in the build
+func moduleVersion() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return ""
+	}
+	if info.Main.Path == modulePath {
+		return info.Main.Version
+	}
+	for _, dep := range info.Deps {
+		if dep.Path == modulePath {
+			return dep.Version
+		}
+	}
+	return ""
+}
+
+// componentOrigin returns the origin of the device of c, for components
+// without device the origin of their manager or DefaultOrigin
+func componentOrigin(c Component) Origin {
+	if device := c.GetDevice(); device != nil {
+		return device.GetOrigin()
+	}
+	if m := componentManager(c); m != nil && m.Origin != nil {
+		return *m.Origin
+	}
+	return DefaultOrigin
+}
@@ -0,0 +1,67 @@
+package homeassistant
+
+import (
+	"math"
+	"time"
+)
+
+// PublishPolicy decides when PublishState actually sends the state to the
+// broker, the zero value publishes every time
+type PublishPolicy struct {
+	// MinInterval between two publishes
+	MinInterval time.Duration
+	// Deadband publishes only when the state changed more than this value
+	Deadband float64
+	// RelativeDeadband publishes only when the state changed more than this
+	// fraction (0.05 = 5%) of the last published state, any change away
+	// from 0 exceeds it
+	RelativeDeadband float64
+	// Heartbeat forces a publish when nothing was published for this long
+	Heartbeat time.Duration
+	// OnChangeOnly publishes only when the state differs from the last published
+	OnChangeOnly bool
+	lastPublish  time.Time
+	lastValue    float64
+}
+
+// allow returns true if value should be published at now
+func (p *PublishPolicy) allow(value float64, now time.Time) bool {
+	if p.lastPublish.IsZero() {
+		return true
+	}
+	since := now.Sub(p.lastPublish)
+	if p.Heartbeat > 0 && since >= p.Heartbeat {
+		return true
+	}
+	if p.MinInterval > 0 && since < p.MinInterval {
+		return false
+	}
+	change := math.Abs(value - p.lastValue)
+	if p.Deadband > 0 || p.RelativeDeadband > 0 {
+		if p.Deadband > 0 && change > p.Deadband {
+			return true
+		}
+		if p.RelativeDeadband > 0 && change > 0 && (p.lastValue == 0 || change/math.Abs(p.lastValue) > p.RelativeDeadband) {
+			return true
+		}
+		return false
+	}
+	if p.OnChangeOnly {
+		return change != 0
+	}
+	return true
+}
+
+// published records that value was published at now
+func (p *PublishPolicy) published(value float64, now time.Time) {
+	p.lastPublish = now
+	p.lastValue = value
+}
+
+// boolValue converts a binary state to a value for the publish policy
+func boolValue(state bool) float64 {
+	if state {
+		return 1
+	}
+	return 0
+}
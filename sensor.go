@@ -4,7 +4,6 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"math"
 	"time"
 
 	MQTT "github.com/eclipse/paho.mqtt.golang"
@@ -25,25 +24,44 @@ type SensorDiscover struct {
 
 // Sensor HA sensor
 type Sensor struct {
-	Ident                 string
-	Name                  string
-	Device                *Device
-	DeviceClass           string
-	Icon                  string
-	UnitOfMeasurement     string
-	States                []float64
-	currentState          float64
-	lastStateUpdate       time.Time
-	AnomalyDetect         bool
-	anomalyDetectFunction func(state float64) error
-	stateRetention        int
+	managed
+	Ident                string
+	Name                 string
+	Device               *Device
+	DeviceClass          string
+	Icon                 string
+	UnitOfMeasurement    string
+	StateClass           string
+	States               []float64
+	stateTimes           []time.Time
+	currentState         float64
+	lastStateUpdate      time.Time
+	AnomalyDetect        bool
+	AnomalyDetector      AnomalyDetector
+	AnomalyHandler       AnomalyHandler
+	EMAAlpha             float64
+	StatisticsAttributes bool
+	stateRetention       int
+	stateMaxAge          time.Duration
+	movingAverageWindow  int
+	stateListeners       []func(*Sensor)
+	PublishPolicy        PublishPolicy
+	StateKey             string
+	ValueTemplate        string
+	EntityAvailability   bool
+	Availability         []Availability
+	AvailabilityMode     string
+	Source               Source
+	Poll                 PollConfig
 }
 
 // NewSensor creates a new sensor with default values
 func NewSensor(ident string) Sensor {
 	sensor := Sensor{
-		stateRetention: 10,
-		Ident:          ident,
+		stateRetention:      defaultStateRetention,
+		movingAverageWindow: defaultMovingAverageWindow,
+		EMAAlpha:            defaultEMAAlpha,
+		Ident:               ident,
 	}
 	return sensor
 }
@@ -62,54 +80,84 @@ func (s *Sensor) GetName() string {
 	return name
 }
 
-func (s *Sensor) basicAnomalyDetect(state float64) error {
-	if len(s.States) > 0 {
-		ma, err := s.MovingAverage()
-		if err != nil {
-			return err
-		}
-		// TODO: Fix dynamic configuration
-		if math.Abs((state-ma)/state) > 0.1 {
-			return errors.New("Change bigger than 10%")
+// detectAnomaly runs the anomaly detector of the sensor, defaulting to a 10%
+// deviation from the moving average when AnomalyDetect is set without one
+func (s *Sensor) detectAnomaly(state float64, at time.Time) error {
+	detector := s.AnomalyDetector
+	if detector == nil {
+		if !s.AnomalyDetect {
+			return nil
 		}
+		detector = PercentageDetector{Threshold: 0.1}
 	}
-
-	return nil
+	err := detector.Detect(s, state, at)
+	if err != nil && s.AnomalyHandler != nil {
+		s.AnomalyHandler(s, state, err)
+	}
+	return err
 }
 
 // AddState to the sensor
 func (s *Sensor) AddState(state float64) error {
-	if s.AnomalyDetect {
-		err := s.basicAnomalyDetect(state)
-		if err != nil {
-			return err
-		}
+	now := time.Now()
+	err := s.detectAnomaly(state, now)
+	if err != nil {
+		return err
 	}
 	s.currentState = state
-	s.lastStateUpdate = time.Now()
-
-	newState := append(s.States, state)
-	if len(newState) <= s.stateRetention {
-		s.States = newState
-	} else {
-		s.States = newState[len(newState)-s.stateRetention:]
+	s.lastStateUpdate = now
+	s.appendState(state, now)
+	for _, listener := range s.stateListeners {
+		listener(s)
 	}
 	return nil
 }
 
+// OnStateChange registers a function called every time the sensor gets a new state
+func (s *Sensor) OnStateChange(listener func(*Sensor)) {
+	s.stateListeners = append(s.stateListeners, listener)
+}
+
 // PublishState publishes last state to broker
 func (s *Sensor) PublishState(broker MQTT.Client) error {
-	token := broker.Publish(s.GetStateTopic(), 0, false, fmt.Sprintf("%.1f", s.State()))
-	token.Wait()
+	now := time.Now()
+	if !s.PublishPolicy.allow(s.State(), now) {
+		return nil
+	}
+	if s.Device.sharesState(s.StateKey) {
+		err := s.Device.PublishState(broker)
+		if err != nil {
+			return err
+		}
+	} else {
+		token := broker.Publish(s.GetStateTopic(), 0, false, fmt.Sprintf("%.1f", s.State()))
+		token.Wait()
+	}
+	s.PublishPolicy.published(s.State(), now)
+	if s.StatisticsAttributes {
+		return s.PublishAttributes(broker)
+	}
 	return nil
 }
 
+// PublishEncoded publishes a pre-encoded state payload to the state topic
+func (s *Sensor) PublishEncoded(broker MQTT.Client, payload PayloadEncoder) error {
+	if s.Device.sharesState(s.StateKey) {
+		return errSharedState
+	}
+	return publishEncoded(broker, s.GetStateTopic(), false, payload)
+}
+
 // MovingAverage calculates moving average of last states
 func (s *Sensor) MovingAverage() (float64, error) {
 	numberOfStates := len(s.States)
 	if len(s.States) == 0 {
 		return 0.0, errors.New("No states to calculate MA on")
 	}
+	window := s.movingAverageWindow
+	if window <= 0 {
+		window = defaultMovingAverageWindow
+	}
 	reversedStates := make([]float64, numberOfStates)
 	copy(reversedStates, s.States)
 	for i, j := 0, len(reversedStates)-1; i < j; i, j = i+1, j-1 {
@@ -117,8 +165,8 @@ func (s *Sensor) MovingAverage() (float64, error) {
 	}
 	sum := 0.0
 	for i, state := range reversedStates {
-		if i > 4 {
-			numberOfStates = 5
+		if i > window-1 {
+			numberOfStates = window
 			break
 		}
 		sum += state
@@ -144,16 +192,39 @@ func (s *Sensor) GetIdent() string {
 	return fmt.Sprintf("%s_%s", s.Device.Ident, s.Ident)
 }
 
+// GetPlatform returns the Home Assistant platform of the sensor
+func (s *Sensor) GetPlatform() string {
+	return "sensor"
+}
+
 // GetBaseTopic for broker
 func (s *Sensor) GetBaseTopic() string {
-	return fmt.Sprintf("homeassistant/sensor/%s", s.GetIdent())
+	return fmt.Sprintf("homeassistant/%s/%s", s.GetPlatform(), s.GetIdent())
 }
 
 // GetStateTopic returns state topic
 func (s *Sensor) GetStateTopic() string {
+	if s.Device.sharesState(s.StateKey) {
+		return s.Device.GetStateTopic()
+	}
 	return fmt.Sprintf("%s/state", s.GetBaseTopic())
 }
 
+// GetStateKey returns the key of the sensor in the shared device state
+func (s *Sensor) GetStateKey() string {
+	return s.StateKey
+}
+
+// GetStateValue returns the value of the sensor in the shared device state
+func (s *Sensor) GetStateValue() interface{} {
+	return s.State()
+}
+
+// GetAttributesTopic returns the json attributes topic
+func (s *Sensor) GetAttributesTopic() string {
+	return fmt.Sprintf("%s/attributes", s.GetBaseTopic())
+}
+
 // GetAvailabilityTopic returns availability topic
 func (s *Sensor) GetAvailabilityTopic() string {
 	if s.Device == nil {
@@ -162,8 +233,33 @@ func (s *Sensor) GetAvailabilityTopic() string {
 	return s.Device.GetAvailabilityTopic()
 }
 
+// GetEntityAvailabilityTopic returns the availability topic of the sensor itself
+func (s *Sensor) GetEntityAvailabilityTopic() string {
+	return fmt.Sprintf("%s/availability", s.GetBaseTopic())
+}
+
+// entityAvailability returns true when the entity availability topic is in
+// the discover payload, polled sensors always need it for failed reads
+func (s *Sensor) entityAvailability() bool {
+	return s.EntityAvailability || s.Source != nil
+}
+
+// PublishAvailable send sensor availability message to broker
+func (s *Sensor) PublishAvailable(broker MQTT.Client) error {
+	return publishAvailability(broker, s.GetName(), s.GetEntityAvailabilityTopic(), payloadAvailable)
+}
+
+// PublishUnavailable send sensor unavailability message to broker
+func (s *Sensor) PublishUnavailable(broker MQTT.Client) error {
+	return publishAvailability(broker, s.GetName(), s.GetEntityAvailabilityTopic(), payloadNotAvailable)
+}
+
 // PublishDiscover publish discover payload to MQTT
 func (s *Sensor) PublishDiscover(broker MQTT.Client) error {
+	err := s.Validate()
+	if err != nil {
+		return err
+	}
 	payload, err := s.GetDiscoverPayload()
 	if err != nil {
 		return err
@@ -175,6 +271,21 @@ func (s *Sensor) PublishDiscover(broker MQTT.Client) error {
 	return nil
 }
 
+// Validate checks the device class together with its unit of measurement
+// and state class
+func (s *Sensor) Validate() error {
+	var v validator
+	v.required("ident", s.Ident)
+	v.topic("state topic", s.GetStateTopic())
+	v.topic("discover topic", s.GetDiscoverTopic())
+	v.topic("availability topic", s.GetAvailabilityTopic())
+	v.availability(s.Availability, s.AvailabilityMode)
+	v.oneOf("device_class", s.DeviceClass, sensorDeviceClasses)
+	v.unit(s.DeviceClass, s.UnitOfMeasurement)
+	v.stateClass(s.DeviceClass, s.StateClass)
+	return v.err(s.GetName())
+}
+
 // GetDiscoverTopic returns discover topic
 func (s *Sensor) GetDiscoverTopic() string {
 	return fmt.Sprintf("%s/config", s.GetBaseTopic())
@@ -182,24 +293,41 @@ func (s *Sensor) GetDiscoverTopic() string {
 
 // GetDiscoverPayload generates disover payload json
 func (s *Sensor) GetDiscoverPayload() ([]byte, error) {
+	availabilityTopic, availability, availabilityMode := discoverAvailability(s, s.GetEntityAvailabilityTopic(), s.entityAvailability(), s.Availability, s.AvailabilityMode)
+	var attributesTopic string
+	if s.StatisticsAttributes {
+		attributesTopic = s.GetAttributesTopic()
+	}
 	return json.Marshal(&struct {
-		UniqueID          string `json:"unique_id"`
-		Name              string `json:"name"`
-		StateTopic        string `json:"stat_t"`
-		AvailabilityTopic string `json:"avty_t,omitempty"`
-		Icon              string `json:"icon,omitempty"`
-		DeviceClass       string `json:"dev_cla,omitempty"`
-		UnitOfMeasurement string `json:"unit_of_meas,omitempty"`
-		Device            Device `json:"device,omitempty"`
+		UniqueID          string         `json:"unique_id"`
+		Name              string         `json:"name"`
+		StateTopic        string         `json:"stat_t"`
+		ValueTemplate     string         `json:"val_tpl,omitempty"`
+		AvailabilityTopic string         `json:"avty_t,omitempty"`
+		Availability      []Availability `json:"avty,omitempty"`
+		AvailabilityMode  string         `json:"avty_mode,omitempty"`
+		AttributesTopic   string         `json:"json_attr_t,omitempty"`
+		Icon              string         `json:"icon,omitempty"`
+		DeviceClass       string         `json:"dev_cla,omitempty"`
+		UnitOfMeasurement string         `json:"unit_of_meas,omitempty"`
+		StateClass        string         `json:"stat_cla,omitempty"`
+		Device            *Device        `json:"device,omitempty"`
+		Origin            Origin         `json:"o"`
 	}{
 		UniqueID:          s.GetIdent(),
 		Name:              s.GetName(),
 		StateTopic:        s.GetStateTopic(),
-		AvailabilityTopic: s.GetAvailabilityTopic(),
+		ValueTemplate:     orDefault(s.ValueTemplate, s.Device.stateValueTemplate(s.StateKey)),
+		AvailabilityTopic: availabilityTopic,
+		Availability:      availability,
+		AvailabilityMode:  availabilityMode,
+		AttributesTopic:   attributesTopic,
 		DeviceClass:       s.DeviceClass,
 		Icon:              s.Icon,
 		UnitOfMeasurement: s.UnitOfMeasurement,
-		Device:            *s.Device,
+		StateClass:        s.StateClass,
+		Device:            s.Device,
+		Origin:            componentOrigin(s),
 	})
 }
 
@@ -22,6 +22,7 @@ type BinarySensorDiscover struct {
 
 // BinarySensor HA sensor
 type BinarySensor struct {
+	managed
 	Ident                 string
 	Name                  string
 	Device                *Device
@@ -29,6 +30,14 @@ type BinarySensor struct {
 	Icon                  string
 	currentState          bool
 	lastStateUpdate       time.Time
+	PublishPolicy         PublishPolicy
+	StateKey              string
+	ValueTemplate         string
+	EntityAvailability    bool
+	Availability          []Availability
+	AvailabilityMode      string
+	Source                BinarySource
+	Poll                  PollConfig
 	AnomalyDetect         bool
 	anomalyDetectFunction func(state float64) error
 }
@@ -67,17 +76,39 @@ func (s *BinarySensor) GetName() string {
 
 // PublishState publishes last state to broker
 func (s *BinarySensor) PublishState(broker MQTT.Client) error {
-	var state string
-	if s.currentState == true {
-		state = "ON"
+	now := time.Now()
+	if !s.PublishPolicy.allow(boolValue(s.currentState), now) {
+		return nil
+	}
+	if s.Device.sharesState(s.StateKey) {
+		err := s.Device.PublishState(broker)
+		if err != nil {
+			return err
+		}
 	} else {
-		state = "OFF"
+		token := broker.Publish(s.GetStateTopic(), 0, false, s.stateString())
+		token.Wait()
 	}
-	token := broker.Publish(s.GetStateTopic(), 0, false, state)
-	token.Wait()
+	s.PublishPolicy.published(boolValue(s.currentState), now)
 	return nil
 }
 
+// PublishEncoded publishes a pre-encoded state payload to the state topic
+func (s *BinarySensor) PublishEncoded(broker MQTT.Client, payload PayloadEncoder) error {
+	if s.Device.sharesState(s.StateKey) {
+		return errSharedState
+	}
+	return publishEncoded(broker, s.GetStateTopic(), false, payload)
+}
+
+// stateString returns the state payload of the sensor
+func (s *BinarySensor) stateString() string {
+	if s.currentState == true {
+		return "ON"
+	}
+	return "OFF"
+}
+
 // State returns current state
 func (s *BinarySensor) State() bool {
 	return s.currentState
@@ -86,6 +117,25 @@ func (s *BinarySensor) State() bool {
 // SetState sets sensor state
 func (s *BinarySensor) SetState(state bool) {
 	s.currentState = state
+	s.lastStateUpdate = time.Now()
+}
+
+// SaveState stores the current state of the sensor
+func (s *BinarySensor) SaveState(store Store) error {
+	return store.Save(storeKey(s), binarySnapshot{State: s.currentState, Updated: s.lastStateUpdate})
+}
+
+// LoadState restores the current state of the sensor, returns false when
+// nothing was stored
+func (s *BinarySensor) LoadState(store Store) (bool, error) {
+	var snapshot binarySnapshot
+	ok, err := store.Load(storeKey(s), &snapshot)
+	if !ok || err != nil {
+		return ok, err
+	}
+	s.currentState = snapshot.State
+	s.lastStateUpdate = snapshot.Updated
+	return true, nil
 }
 
 // lastState is the last time the sensor was updates
@@ -101,16 +151,34 @@ func (s *BinarySensor) GetIdent() string {
 	return fmt.Sprintf("%s_%s", s.Device.Ident, s.Ident)
 }
 
+// GetPlatform returns the Home Assistant platform of the binary sensor
+func (s *BinarySensor) GetPlatform() string {
+	return "binary_sensor"
+}
+
 // GetBaseTopic for broker
 func (s *BinarySensor) GetBaseTopic() string {
-	return fmt.Sprintf("homeassistant/binary_sensor/%s", s.GetIdent())
+	return fmt.Sprintf("homeassistant/%s/%s", s.GetPlatform(), s.GetIdent())
 }
 
 // GetStateTopic returns state topic
 func (s *BinarySensor) GetStateTopic() string {
+	if s.Device.sharesState(s.StateKey) {
+		return s.Device.GetStateTopic()
+	}
 	return fmt.Sprintf("%s/state", s.GetBaseTopic())
 }
 
+// GetStateKey returns the key of the sensor in the shared device state
+func (s *BinarySensor) GetStateKey() string {
+	return s.StateKey
+}
+
+// GetStateValue returns the value of the sensor in the shared device state
+func (s *BinarySensor) GetStateValue() interface{} {
+	return s.stateString()
+}
+
 // GetAvailabilityTopic returns availability topic
 func (s *BinarySensor) GetAvailabilityTopic() string {
 	if s.Device == nil {
@@ -119,8 +187,33 @@ func (s *BinarySensor) GetAvailabilityTopic() string {
 	return s.Device.GetAvailabilityTopic()
 }
 
+// GetEntityAvailabilityTopic returns the availability topic of the binary sensor itself
+func (s *BinarySensor) GetEntityAvailabilityTopic() string {
+	return fmt.Sprintf("%s/availability", s.GetBaseTopic())
+}
+
+// entityAvailability returns true when the entity availability topic is in
+// the discover payload, polled sensors always need it for failed reads
+func (s *BinarySensor) entityAvailability() bool {
+	return s.EntityAvailability || s.Source != nil
+}
+
+// PublishAvailable send binary sensor availability message to broker
+func (s *BinarySensor) PublishAvailable(broker MQTT.Client) error {
+	return publishAvailability(broker, s.GetName(), s.GetEntityAvailabilityTopic(), payloadAvailable)
+}
+
+// PublishUnavailable send binary sensor unavailability message to broker
+func (s *BinarySensor) PublishUnavailable(broker MQTT.Client) error {
+	return publishAvailability(broker, s.GetName(), s.GetEntityAvailabilityTopic(), payloadNotAvailable)
+}
+
 // PublishDiscover publish discover payload to MQTT
 func (s *BinarySensor) PublishDiscover(broker MQTT.Client) error {
+	err := s.Validate()
+	if err != nil {
+		return err
+	}
 	payload, err := s.GetDiscoverPayload()
 	if err != nil {
 		return err
@@ -132,6 +225,18 @@ func (s *BinarySensor) PublishDiscover(broker MQTT.Client) error {
 	return nil
 }
 
+// Validate checks the binary sensor ident, topics and device class
+func (s *BinarySensor) Validate() error {
+	var v validator
+	v.required("ident", s.Ident)
+	v.topic("state topic", s.GetStateTopic())
+	v.topic("discover topic", s.GetDiscoverTopic())
+	v.topic("availability topic", s.GetAvailabilityTopic())
+	v.availability(s.Availability, s.AvailabilityMode)
+	v.oneOf("device_class", s.DeviceClass, binarySensorDeviceClasses)
+	return v.err(s.GetName())
+}
+
 // GetDiscoverTopic returns discover topic
 func (s *BinarySensor) GetDiscoverTopic() string {
 	return fmt.Sprintf("%s/config", s.GetBaseTopic())
@@ -139,19 +244,30 @@ func (s *BinarySensor) GetDiscoverTopic() string {
 
 // GetDiscoverPayload generates disover payload json
 func (s *BinarySensor) GetDiscoverPayload() ([]byte, error) {
+	availabilityTopic, availability, availabilityMode := discoverAvailability(s, s.GetEntityAvailabilityTopic(), s.entityAvailability(), s.Availability, s.AvailabilityMode)
 	return json.Marshal(&struct {
-		UniqueID          string `json:"unique_id"`
-		Name              string `json:"name"`
-		StateTopic        string `json:"stat_t"`
-		AvailabilityTopic string `json:"avty_t,omitempty"`
-		Icon              string `json:"icon,omitempty"`
-		Device            Device `json:"device,omitempty"`
+		UniqueID          string         `json:"unique_id"`
+		Name              string         `json:"name"`
+		StateTopic        string         `json:"stat_t"`
+		ValueTemplate     string         `json:"val_tpl,omitempty"`
+		AvailabilityTopic string         `json:"avty_t,omitempty"`
+		Availability      []Availability `json:"avty,omitempty"`
+		AvailabilityMode  string         `json:"avty_mode,omitempty"`
+		Icon              string         `json:"icon,omitempty"`
+		DeviceClass       string         `json:"dev_cla,omitempty"`
+		Device            *Device        `json:"device,omitempty"`
+		Origin            Origin         `json:"o"`
 	}{
 		UniqueID:          s.GetIdent(),
 		Name:              s.GetName(),
 		StateTopic:        s.GetStateTopic(),
-		AvailabilityTopic: s.GetAvailabilityTopic(),
+		ValueTemplate:     orDefault(s.ValueTemplate, s.Device.stateValueTemplate(s.StateKey)),
+		AvailabilityTopic: availabilityTopic,
+		Availability:      availability,
+		AvailabilityMode:  availabilityMode,
 		Icon:              s.Icon,
-		Device:            *s.Device,
+		DeviceClass:       s.DeviceClass,
+		Device:            s.Device,
+		Origin:            componentOrigin(s),
 	})
 }
@@ -0,0 +1,100 @@
+package homeassistant
+
+import (
+	"errors"
+
+	MQTT "github.com/eclipse/paho.mqtt.golang"
+	log "github.com/sirupsen/logrus"
+)
+
+// DeriveFunc computes the derived state from the input sensors
+type DeriveFunc func(inputs []*Sensor) (float64, error)
+
+// DerivedSensor HA sensor computed from other sensors on the same device
+type DerivedSensor struct {
+	Sensor
+	Inputs  []*Sensor
+	Compute DeriveFunc
+	// Broker the state is published to, defaults to the client of the Manager
+	Broker   MQTT.Client
+	attached bool
+}
+
+// NewDerivedSensor creates a new derived sensor with default values
+func NewDerivedSensor(ident string, compute DeriveFunc, inputs ...*Sensor) DerivedSensor {
+	sensor := DerivedSensor{
+		Sensor:  NewSensor(ident),
+		Inputs:  inputs,
+		Compute: compute,
+	}
+	return sensor
+}
+
+// SetDevice of sensor and start listening to the inputs
+func (s *DerivedSensor) SetDevice(device *Device) {
+	s.Sensor.SetDevice(device)
+	for _, input := range s.Inputs {
+		if input.Device != device {
+			log.Warnf("Derived sensor %s input %s is not on the same device", s.GetName(), input.GetName())
+		}
+	}
+	s.attach()
+}
+
+// attach registers the derived sensor as listener on all inputs, only once
+func (s *DerivedSensor) attach() {
+	if s.attached {
+		return
+	}
+	s.attached = true
+	for _, input := range s.Inputs {
+		input.OnStateChange(s.inputChanged)
+	}
+}
+
+// inputChanged recomputes the state when an input gets a new state
+func (s *DerivedSensor) inputChanged(input *Sensor) {
+	err := s.Recompute()
+	if err != nil {
+		log.Warnf("Derived sensor %s not updated: %s", s.GetName(), err)
+		return
+	}
+	if s.lastState().IsZero() {
+		return
+	}
+	broker := s.broker()
+	if broker != nil && broker.IsConnected() {
+		err = s.PublishState(broker)
+		if err != nil {
+			log.Warnf("Derived sensor %s not published: %s", s.GetName(), err)
+		}
+	}
+}
+
+// broker returns Broker, or the client of the manager of the sensor
+func (s *DerivedSensor) broker() MQTT.Client {
+	if s.Broker != nil {
+		return s.Broker
+	}
+	if m := componentManager(s); m != nil {
+		return m.Client
+	}
+	return nil
+}
+
+// Recompute the state from the inputs, skipped until every input has a state
+func (s *DerivedSensor) Recompute() error {
+	if s.Compute == nil {
+		return errors.New("Derived sensor has no compute function")
+	}
+	for _, input := range s.Inputs {
+		if input.lastState().IsZero() {
+			return nil
+		}
+	}
+	state, err := s.Compute(s.Inputs)
+	if err != nil {
+		return err
+	}
+	return s.AddState(state)
+}
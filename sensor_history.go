@@ -0,0 +1,267 @@
+package homeassistant
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"time"
+
+	MQTT "github.com/eclipse/paho.mqtt.golang"
+)
+
+const (
+	defaultStateRetention      = 10
+	defaultMovingAverageWindow = 5
+	defaultEMAAlpha            = 0.5
+)
+
+// Reading is a sensor state with the time it was added
+type Reading struct {
+	Value float64
+	Time  time.Time
+}
+
+// SensorStatistics rolling statistics over the sensor history
+type SensorStatistics struct {
+	Min                 float64 `json:"min"`
+	Max                 float64 `json:"max"`
+	Mean                float64 `json:"mean"`
+	Median              float64 `json:"median"`
+	StdDev              float64 `json:"std_dev"`
+	EMA                 float64 `json:"ema"`
+	TimeWeightedAverage float64 `json:"time_weighted_average"`
+	Count               int     `json:"count"`
+}
+
+var errNoStates = errors.New("No states to calculate statistics on")
+
+// SetStateRetention sets the number of states kept, 0 disables the limit
+// when a max age is set
+func (s *Sensor) SetStateRetention(count int) {
+	s.stateRetention = count
+	s.trimStates(time.Now())
+}
+
+// SetStateMaxAge sets how long states are kept, 0 disables the limit
+func (s *Sensor) SetStateMaxAge(age time.Duration) {
+	s.stateMaxAge = age
+	s.trimStates(time.Now())
+}
+
+// SetMovingAverageWindow sets the number of states used by MovingAverage
+func (s *Sensor) SetMovingAverageWindow(window int) {
+	s.movingAverageWindow = window
+}
+
+// appendState adds state to the history and trims it to the configured window
+func (s *Sensor) appendState(state float64, at time.Time) {
+	s.alignStateTimes()
+	s.States = append(s.States, state)
+	s.stateTimes = append(s.stateTimes, at)
+	s.trimStates(at)
+}
+
+// alignStateTimes pads stateTimes when States has been set directly
+func (s *Sensor) alignStateTimes() {
+	if len(s.stateTimes) > len(s.States) {
+		s.stateTimes = s.stateTimes[len(s.stateTimes)-len(s.States):]
+	}
+	for len(s.stateTimes) < len(s.States) {
+		s.stateTimes = append([]time.Time{{}}, s.stateTimes...)
+	}
+}
+
+// trimStates removes states outside of the retention count and age
+func (s *Sensor) trimStates(now time.Time) {
+	s.alignStateTimes()
+	retention := s.stateRetention
+	if retention <= 0 && s.stateMaxAge <= 0 {
+		retention = defaultStateRetention
+	}
+	start := 0
+	if retention > 0 && len(s.States) > retention {
+		start = len(s.States) - retention
+	}
+	if s.stateMaxAge > 0 {
+		for start < len(s.States) && now.Sub(s.stateTimes[start]) > s.stateMaxAge {
+			start++
+		}
+	}
+	s.States = s.States[start:]
+	s.stateTimes = s.stateTimes[start:]
+}
+
+// Readings returns the stored states with timestamps, oldest first
+func (s *Sensor) Readings() []Reading {
+	s.alignStateTimes()
+	readings := make([]Reading, len(s.States))
+	for i, state := range s.States {
+		readings[i] = Reading{Value: state, Time: s.stateTimes[i]}
+	}
+	return readings
+}
+
+// Min returns the lowest stored state
+func (s *Sensor) Min() (float64, error) {
+	if len(s.States) == 0 {
+		return 0.0, errNoStates
+	}
+	min := s.States[0]
+	for _, state := range s.States[1:] {
+		min = math.Min(min, state)
+	}
+	return min, nil
+}
+
+// Max returns the highest stored state
+func (s *Sensor) Max() (float64, error) {
+	if len(s.States) == 0 {
+		return 0.0, errNoStates
+	}
+	max := s.States[0]
+	for _, state := range s.States[1:] {
+		max = math.Max(max, state)
+	}
+	return max, nil
+}
+
+// Mean returns the mean of the stored states
+func (s *Sensor) Mean() (float64, error) {
+	if len(s.States) == 0 {
+		return 0.0, errNoStates
+	}
+	return mean(s.States), nil
+}
+
+// Median returns the median of the stored states
+func (s *Sensor) Median() (float64, error) {
+	if len(s.States) == 0 {
+		return 0.0, errNoStates
+	}
+	return median(s.States), nil
+}
+
+// StdDev returns the standard deviation of the stored states
+func (s *Sensor) StdDev() (float64, error) {
+	if len(s.States) == 0 {
+		return 0.0, errNoStates
+	}
+	return stdDev(s.States), nil
+}
+
+// ExponentialMovingAverage of the stored states using EMAAlpha, 0.5 when unset
+func (s *Sensor) ExponentialMovingAverage() (float64, error) {
+	if len(s.States) == 0 {
+		return 0.0, errNoStates
+	}
+	alpha := s.EMAAlpha
+	if alpha == 0 {
+		alpha = defaultEMAAlpha
+	}
+	if alpha < 0 || alpha > 1 {
+		return 0.0, errors.New("EMAAlpha must be between 0 and 1")
+	}
+	ema := s.States[0]
+	for _, state := range s.States[1:] {
+		ema = alpha*state + (1-alpha)*ema
+	}
+	return ema, nil
+}
+
+// TimeWeightedAverage weighs every state by how long it was the current
+// state, the last state is weighted until now
+func (s *Sensor) TimeWeightedAverage() (float64, error) {
+	return s.timeWeightedAverage(time.Now())
+}
+
+func (s *Sensor) timeWeightedAverage(now time.Time) (float64, error) {
+	readings := s.Readings()
+	if len(readings) == 0 {
+		return 0.0, errNoStates
+	}
+	sum := 0.0
+	total := 0.0
+	for i, r := range readings {
+		if r.Time.IsZero() {
+			continue
+		}
+		end := now
+		if i < len(readings)-1 {
+			end = readings[i+1].Time
+		}
+		weight := end.Sub(r.Time).Seconds()
+		if weight <= 0 {
+			continue
+		}
+		sum += r.Value * weight
+		total += weight
+	}
+	if total == 0 {
+		return mean(s.States), nil
+	}
+	return sum / total, nil
+}
+
+// Statistics returns all rolling statistics of the sensor
+func (s *Sensor) Statistics() (SensorStatistics, error) {
+	if len(s.States) == 0 {
+		return SensorStatistics{}, errNoStates
+	}
+	stats := SensorStatistics{Count: len(s.States)}
+	stats.Min, _ = s.Min()
+	stats.Max, _ = s.Max()
+	stats.Mean, _ = s.Mean()
+	stats.Median, _ = s.Median()
+	stats.StdDev, _ = s.StdDev()
+	stats.TimeWeightedAverage, _ = s.TimeWeightedAverage()
+	ema, err := s.ExponentialMovingAverage()
+	if err != nil {
+		return stats, err
+	}
+	stats.EMA = ema
+	return stats, nil
+}
+
+// PublishAttributes publishes the rolling statistics as json attributes
+func (s *Sensor) PublishAttributes(broker MQTT.Client) error {
+	stats, err := s.Statistics()
+	if err != nil {
+		return err
+	}
+	payload, err := json.Marshal(stats)
+	if err != nil {
+		return err
+	}
+	token := broker.Publish(s.GetAttributesTopic(), 0, false, payload)
+	token.Wait()
+	return nil
+}
+
+// SaveState stores the current state and history of the sensor
+func (s *Sensor) SaveState(store Store) error {
+	return store.Save(storeKey(s), sensorSnapshot{
+		State:    s.currentState,
+		Updated:  s.lastStateUpdate,
+		Readings: s.Readings(),
+	})
+}
+
+// LoadState restores the current state and history of the sensor, returns
+// false when nothing was stored
+func (s *Sensor) LoadState(store Store) (bool, error) {
+	var snapshot sensorSnapshot
+	ok, err := store.Load(storeKey(s), &snapshot)
+	if !ok || err != nil {
+		return ok, err
+	}
+	s.currentState = snapshot.State
+	s.lastStateUpdate = snapshot.Updated
+	s.States = make([]float64, len(snapshot.Readings))
+	s.stateTimes = make([]time.Time, len(snapshot.Readings))
+	for i, r := range snapshot.Readings {
+		s.States[i] = r.Value
+		s.stateTimes[i] = r.Time
+	}
+	s.trimStates(time.Now())
+	return true, nil
+}
@@ -0,0 +1,326 @@
+package homeassistant
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	MQTT "github.com/eclipse/paho.mqtt.golang"
+	log "github.com/sirupsen/logrus"
+)
+
+// SirenCommand is a command received from Home Assistant, Tone, Duration and
+// VolumeLevel keep their previous values when the command omits them
+type SirenCommand struct {
+	On          bool
+	Tone        string
+	Duration    int
+	VolumeLevel float64
+}
+
+// SirenCommandFunc sounds or silences the siren, an error leaves the siren
+// reported as it was
+type SirenCommandFunc func(command SirenCommand) error
+
+// sirenPayload is the json command and state of a siren
+type sirenPayload struct {
+	State       string   `json:"state"`
+	Tone        string   `json:"tone,omitempty"`
+	Duration    int      `json:"duration,omitempty"`
+	VolumeLevel *float64 `json:"volume_level,omitempty"`
+}
+
+// Siren HA siren with optional tones, volume and duration
+type Siren struct {
+	managed
+	Ident              string
+	Name               string
+	Device             *Device
+	Icon               string
+	AvailableTones     []string
+	SupportDuration    bool
+	SupportVolumeSet   bool
+	Optimistic         bool
+	PayloadOn          string
+	PayloadOff         string
+	EntityAvailability bool
+	Availability       []Availability
+	AvailabilityMode   string
+	currentState       SirenCommand
+	commandFunc        SirenCommandFunc
+}
+
+// NewSiren creates a new siren with default values
+func NewSiren(ident string) Siren {
+	s := Siren{
+		Ident:      ident,
+		PayloadOn:  "ON",
+		PayloadOff: "OFF",
+	}
+	return s
+}
+
+// State returns current state
+func (s *Siren) State() SirenCommand {
+	return s.currentState
+}
+
+// SetState sets the siren state
+func (s *Siren) SetState(state SirenCommand) {
+	s.currentState = state
+}
+
+func (s *Siren) stateString(on bool) string {
+	if on {
+		return orDefault(s.PayloadOn, "ON")
+	}
+	return orDefault(s.PayloadOff, "OFF")
+}
+
+// PublishState publishes the json state to broker
+func (s *Siren) PublishState(broker MQTT.Client) error {
+	payload := sirenPayload{
+		State: s.stateString(s.currentState.On),
+		Tone:  s.currentState.Tone,
+	}
+	if s.SupportDuration {
+		payload.Duration = s.currentState.Duration
+	}
+	if s.SupportVolumeSet {
+		payload.VolumeLevel = &s.currentState.VolumeLevel
+	}
+	return publishEncoded(broker, s.GetStateTopic(), false, JSONPayload{Value: payload})
+}
+
+// GetCommandTopic returns the command topic
+func (s *Siren) GetCommandTopic() string {
+	return fmt.Sprintf("%s/command", s.GetBaseTopic())
+}
+
+// SubscribeCommand subscribe to command channel
+func (s *Siren) SubscribeCommand(broker MQTT.Client, function SirenCommandFunc) error {
+	s.commandFunc = function
+	token := broker.Subscribe(s.GetCommandTopic(), 0, s.CommandReceived)
+	token.Wait()
+	return token.Error()
+}
+
+// CommandReceived when getting a message from topic
+func (s *Siren) CommandReceived(broker MQTT.Client, message MQTT.Message) {
+	err := s.HandleCommand(broker, string(message.Payload()))
+	if err != nil {
+		log.Warnf("Siren %s command failed: %s", s.GetName(), err)
+	}
+}
+
+// parseCommand parses a json command, or a plain on or off payload, on top
+// of the current state
+func (s *Siren) parseCommand(payload string) (SirenCommand, error) {
+	command := s.currentState
+	var p sirenPayload
+	if strings.HasPrefix(strings.TrimSpace(payload), "{") {
+		err := json.Unmarshal([]byte(payload), &p)
+		if err != nil {
+			return command, err
+		}
+	} else {
+		p.State = payload
+	}
+	switch p.State {
+	case orDefault(s.PayloadOn, "ON"):
+		command.On = true
+	case orDefault(s.PayloadOff, "OFF"):
+		command.On = false
+		return command, nil
+	default:
+		return command, fmt.Errorf("Unknown payload %s", payload)
+	}
+	if p.Tone != "" && !containsString(s.AvailableTones, p.Tone) {
+		return command, fmt.Errorf("Unknown tone %s", p.Tone)
+	}
+	if p.Duration != 0 && !s.SupportDuration {
+		return command, fmt.Errorf("Siren doesn't support duration")
+	}
+	if p.VolumeLevel != nil {
+		if !s.SupportVolumeSet {
+			return command, fmt.Errorf("Siren doesn't support volume")
+		}
+		if *p.VolumeLevel < 0 || *p.VolumeLevel > 1 {
+			return command, fmt.Errorf("Volume level %.2f out of range 0-1", *p.VolumeLevel)
+		}
+		command.VolumeLevel = *p.VolumeLevel
+	}
+	if p.Tone != "" {
+		command.Tone = p.Tone
+	}
+	if p.Duration != 0 {
+		command.Duration = p.Duration
+	}
+	return command, nil
+}
+
+// HandleCommand sounds or silences the siren and publishes the new state if
+// the command function succeeds
+func (s *Siren) HandleCommand(broker MQTT.Client, payload string) error {
+	command, err := s.parseCommand(payload)
+	if err != nil {
+		return err
+	}
+	if s.commandFunc != nil {
+		err := s.commandFunc(command)
+		if err != nil {
+			return err
+		}
+	}
+	unlock := lockState(s)
+	defer unlock()
+	s.SetState(command)
+	return s.PublishState(broker)
+}
+
+// Validate checks none of the available tones is empty
+func (s *Siren) Validate() error {
+	var v validator
+	v.required("ident", s.Ident)
+	for i, tone := range s.AvailableTones {
+		v.required(fmt.Sprintf("available_tones[%d]", i), tone)
+	}
+	v.topic("state topic", s.GetStateTopic())
+	v.topic("command topic", s.GetCommandTopic())
+	v.topic("discover topic", s.GetDiscoverTopic())
+	v.topic("availability topic", s.GetAvailabilityTopic())
+	v.availability(s.Availability, s.AvailabilityMode)
+	return v.err(s.GetName())
+}
+
+// GetDiscoverPayload generates discover payload json
+func (s *Siren) GetDiscoverPayload() ([]byte, error) {
+	availabilityTopic, availability, availabilityMode := discoverAvailability(s, s.GetEntityAvailabilityTopic(), s.EntityAvailability, s.Availability, s.AvailabilityMode)
+	return json.Marshal(&struct {
+		UniqueID          string         `json:"unique_id"`
+		Name              string         `json:"name"`
+		StateTopic        string         `json:"stat_t"`
+		CommandTopic      string         `json:"cmd_t"`
+		AvailableTones    []string       `json:"available_tones,omitempty"`
+		SupportDuration   bool           `json:"sup_dur"`
+		SupportVolumeSet  bool           `json:"sup_vol"`
+		Optimistic        bool           `json:"opt,omitempty"`
+		PayloadOn         string         `json:"pl_on,omitempty"`
+		PayloadOff        string         `json:"pl_off,omitempty"`
+		AvailabilityTopic string         `json:"avty_t,omitempty"`
+		Availability      []Availability `json:"avty,omitempty"`
+		AvailabilityMode  string         `json:"avty_mode,omitempty"`
+		Icon              string         `json:"icon,omitempty"`
+		Device            *Device        `json:"device,omitempty"`
+		Origin            Origin         `json:"o"`
+	}{
+		UniqueID:          s.GetIdent(),
+		Name:              s.GetName(),
+		StateTopic:        s.GetStateTopic(),
+		CommandTopic:      s.GetCommandTopic(),
+		AvailableTones:    s.AvailableTones,
+		SupportDuration:   s.SupportDuration,
+		SupportVolumeSet:  s.SupportVolumeSet,
+		Optimistic:        s.Optimistic,
+		PayloadOn:         s.PayloadOn,
+		PayloadOff:        s.PayloadOff,
+		AvailabilityTopic: availabilityTopic,
+		Availability:      availability,
+		AvailabilityMode:  availabilityMode,
+		Icon:              s.Icon,
+		Device:            s.Device,
+		Origin:            componentOrigin(s),
+	})
+}
+
+// GetDevice of siren
+func (s *Siren) GetDevice() *Device {
+	return s.Device
+}
+
+// SetDevice of siren
+func (s *Siren) SetDevice(device *Device) {
+	s.Device = device
+}
+
+// GetName of the siren
+func (s *Siren) GetName() string {
+	var name string
+	if s.Name == "" {
+		name = s.Ident
+	} else {
+		name = s.Name
+	}
+	if s.Device != nil {
+		return fmt.Sprintf("%s %s", s.Device.Name, name)
+	}
+	return name
+}
+
+// GetIdent of the siren
+func (s *Siren) GetIdent() string {
+	if s.Device == nil {
+		return s.Ident
+	}
+	return fmt.Sprintf("%s_%s", s.Device.Ident, s.Ident)
+}
+
+// GetPlatform returns the Home Assistant platform of the siren
+func (s *Siren) GetPlatform() string {
+	return "siren"
+}
+
+// GetBaseTopic for broker
+func (s *Siren) GetBaseTopic() string {
+	return fmt.Sprintf("homeassistant/%s/%s", s.GetPlatform(), s.GetIdent())
+}
+
+// GetStateTopic returns state topic
+func (s *Siren) GetStateTopic() string {
+	return fmt.Sprintf("%s/state", s.GetBaseTopic())
+}
+
+// GetAvailabilityTopic returns availability topic
+func (s *Siren) GetAvailabilityTopic() string {
+	if s.Device == nil {
+		return fmt.Sprintf("%s/availability", s.GetBaseTopic())
+	}
+	return s.Device.GetAvailabilityTopic()
+}
+
+// GetEntityAvailabilityTopic returns the availability topic of the siren itself
+func (s *Siren) GetEntityAvailabilityTopic() string {
+	return fmt.Sprintf("%s/availability", s.GetBaseTopic())
+}
+
+// PublishAvailable send siren availability message to broker
+func (s *Siren) PublishAvailable(broker MQTT.Client) error {
+	return publishAvailability(broker, s.GetName(), s.GetEntityAvailabilityTopic(), payloadAvailable)
+}
+
+// PublishUnavailable send siren unavailability message to broker
+func (s *Siren) PublishUnavailable(broker MQTT.Client) error {
+	return publishAvailability(broker, s.GetName(), s.GetEntityAvailabilityTopic(), payloadNotAvailable)
+}
+
+// PublishDiscover publish discover payload to MQTT
+func (s *Siren) PublishDiscover(broker MQTT.Client) error {
+	err := s.Validate()
+	if err != nil {
+		return err
+	}
+	payload, err := s.GetDiscoverPayload()
+	if err != nil {
+		return err
+	}
+	token := broker.Publish(s.GetDiscoverTopic(), 0, true, payload)
+	log.Infof("Publishing siren %s discovery to %s", s.GetName(), s.GetDiscoverTopic())
+	log.Debug(string(payload))
+	token.Wait()
+	return nil
+}
+
+// GetDiscoverTopic returns discover topic
+func (s *Siren) GetDiscoverTopic() string {
+	return fmt.Sprintf("%s/config", s.GetBaseTopic())
+}
@@ -0,0 +1,83 @@
+package homeassistant
+
+import (
+	"context"
+	"time"
+)
+
+// Source reads the state of a sensor from hardware or another system
+type Source interface {
+	Read(ctx context.Context) (float64, error)
+}
+
+// SourceFunc adapts a function to a Source
+type SourceFunc func(ctx context.Context) (float64, error)
+
+// Read calls the function
+func (f SourceFunc) Read(ctx context.Context) (float64, error) {
+	return f(ctx)
+}
+
+// BinarySource reads the state of a binary sensor
+type BinarySource interface {
+	Read(ctx context.Context) (bool, error)
+}
+
+// BinarySourceFunc adapts a function to a BinarySource
+type BinarySourceFunc func(ctx context.Context) (bool, error)
+
+// Read calls the function
+func (f BinarySourceFunc) Read(ctx context.Context) (bool, error) {
+	return f(ctx)
+}
+
+// PollConfig how a source is polled by the Manager
+type PollConfig struct {
+	// Interval between reads, defaults to 30 seconds
+	Interval time.Duration
+	// Jitter is the maximum random delay added to every interval
+	Jitter time.Duration
+	// Timeout for a single read, defaults to Interval
+	Timeout time.Duration
+	// MaxBackoff caps the exponential backoff after failed reads, defaults to 10 times Interval
+	MaxBackoff time.Duration
+	// MaxFailures in a row before the entity is marked unavailable, defaults to 3
+	MaxFailures int
+}
+
+func (c PollConfig) interval() time.Duration {
+	if c.Interval <= 0 {
+		return 30 * time.Second
+	}
+	return c.Interval
+}
+
+func (c PollConfig) timeout() time.Duration {
+	if c.Timeout <= 0 {
+		return c.interval()
+	}
+	return c.Timeout
+}
+
+func (c PollConfig) maxFailures() int {
+	if c.MaxFailures <= 0 {
+		return 3
+	}
+	return c.MaxFailures
+}
+
+// backoff returns the delay before the next read after failures reads failed in a row
+func (c PollConfig) backoff(failures int) time.Duration {
+	max := c.MaxBackoff
+	if max <= 0 {
+		max = 10 * c.interval()
+	}
+	delay := c.interval()
+	for i := 0; i < failures && delay < max; i++ {
+		delay *= 2
+	}
+	if delay > max {
+		return max
+	}
+	return delay
+}
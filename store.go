@@ -0,0 +1,142 @@
+package homeassistant
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Store persists component states, sensor history and other values such as
+// counters across restarts
+type Store interface {
+	// Load value of key into v, returns false if key isn't stored
+	Load(key string, v interface{}) (bool, error)
+	// Save v as value of key
+	Save(key string, v interface{}) error
+	// Flush saved values to persistent storage
+	Flush() error
+}
+
+// FileStore is a Store keeping all values in a json file in a directory,
+// writes replace the file atomically so a crash never leaves a partial file
+type FileStore struct {
+	Dir    string
+	mu     sync.Mutex
+	values map[string]json.RawMessage
+	dirty  bool
+}
+
+const fileStoreName = "state.json"
+
+// NewFileStore returns a store in dir, loading previously flushed values
+func NewFileStore(dir string) (*FileStore, error) {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, err
+	}
+	s := &FileStore{
+		Dir:    dir,
+		values: map[string]json.RawMessage{},
+	}
+	data, err := ioutil.ReadFile(s.path())
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(data, &s.values)
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *FileStore) path() string {
+	return filepath.Join(s.Dir, fileStoreName)
+}
+
+// Load value of key into v
+func (s *FileStore) Load(key string, v interface{}) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data, ok := s.values[key]
+	if !ok {
+		return false, nil
+	}
+	return true, json.Unmarshal(data, v)
+}
+
+// Save v as value of key, it is written to disk on the next Flush
+func (s *FileStore) Save(key string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.values[key] = data
+	s.dirty = true
+	return nil
+}
+
+// Flush writes all values to a temporary file and renames it over the state file
+func (s *FileStore) Flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.dirty {
+		return nil
+	}
+	data, err := json.Marshal(s.values)
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(s.Dir, fileStoreName+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	err = os.Rename(tmp.Name(), s.path())
+	if err != nil {
+		return err
+	}
+	s.dirty = false
+	return nil
+}
+
+// persistent is implemented by components storing their state in a Store
+type persistent interface {
+	SaveState(Store) error
+	LoadState(Store) (bool, error)
+}
+
+// sensorSnapshot is the stored state of a Sensor
+type sensorSnapshot struct {
+	State    float64   `json:"state"`
+	Updated  time.Time `json:"updated"`
+	Readings []Reading `json:"readings"`
+}
+
+// binarySnapshot is the stored state of a BinarySensor or Switch
+type binarySnapshot struct {
+	State   bool      `json:"state"`
+	Updated time.Time `json:"updated"`
+}
+
+// storeKey of a component in a Store
+func storeKey(c Component) string {
+	return "component/" + c.GetIdent()
+}
@@ -22,20 +22,43 @@ type SwitchDiscover struct {
 
 // Switch HA sensor
 type Switch struct {
-	Ident           string
-	Name            string
-	Device          *Device
-	Icon            string
-	DefaultState    bool
-	currentState    bool
-	lastStateUpdate time.Time
-	toggleFunc      func(string)
+	managed
+	Ident              string
+	Name               string
+	Device             *Device
+	Icon               string
+	DeviceClass        string
+	DefaultState       bool
+	currentState       bool
+	lastStateUpdate    time.Time
+	PublishPolicy      PublishPolicy
+	StateKey           string
+	ValueTemplate      string
+	CommandTemplate    string
+	EntityAvailability bool
+	Availability       []Availability
+	AvailabilityMode   string
+	Optimistic         bool
+	RetainState        bool
+	PayloadOn          string
+	PayloadOff         string
+	StateOn            string
+	StateOff           string
+	toggleFunc         SwitchCommandFunc
 }
 
+// SwitchCommandFunc switches the hardware, the state is only updated and
+// published when it returns nil
+type SwitchCommandFunc func(state bool) error
+
 // NewSwitch creates a new switch with default values
 func NewSwitch(ident string) Switch {
 	s := Switch{
-		Ident: ident,
+		Ident:      ident,
+		PayloadOn:  "ON",
+		PayloadOff: "OFF",
+		StateOn:    "ON",
+		StateOff:   "OFF",
 	}
 	return s
 }
@@ -66,36 +89,120 @@ func (s *Switch) GetName() string {
 
 // PublishState publishes last state to broker
 func (s *Switch) PublishState(broker MQTT.Client) error {
-	var state string
-	if s.currentState == true {
-		state = "ON"
+	now := time.Now()
+	if !s.PublishPolicy.allow(boolValue(s.currentState), now) {
+		return nil
+	}
+	if s.Device.sharesState(s.StateKey) {
+		err := s.Device.PublishState(broker)
+		if err != nil {
+			return err
+		}
 	} else {
-		state = "OFF"
+		token := broker.Publish(s.GetStateTopic(), 0, s.RetainState, s.stateString())
+		token.Wait()
 	}
-	token := broker.Publish(s.GetStateTopic(), 0, false, state)
-	token.Wait()
+	s.PublishPolicy.published(boolValue(s.currentState), now)
 	return nil
 }
 
+// PublishEncoded publishes a pre-encoded state payload to the state topic
+func (s *Switch) PublishEncoded(broker MQTT.Client, payload PayloadEncoder) error {
+	if s.Device.sharesState(s.StateKey) {
+		return errSharedState
+	}
+	return publishEncoded(broker, s.GetStateTopic(), s.RetainState, payload)
+}
+
 // SubscribeCommand subscribe to command chnnel
-func (s *Switch) SubscribeCommand(broker MQTT.Client, function func(string)) error {
-	broker.Subscribe(s.GetCommandTopic(), 0, s.CommandReceived)
+func (s *Switch) SubscribeCommand(broker MQTT.Client, function SwitchCommandFunc) error {
 	s.toggleFunc = function
-	return nil
+	token := broker.Subscribe(s.GetCommandTopic(), 0, s.CommandReceived)
+	token.Wait()
+	return token.Error()
 }
 
 // CommandReceived when getting a message from topic
 func (s *Switch) CommandReceived(broker MQTT.Client, message MQTT.Message) {
-	payload := string(message.Payload())
-	if payload == "ON" {
-		s.toggleFunc("ON")
-		s.SetState(true)
-		s.PublishState(broker)
-	} else {
-		s.toggleFunc("OFF")
-		s.SetState(false)
-		s.PublishState(broker)
+	err := s.HandleCommand(broker, string(message.Payload()))
+	if err != nil {
+		log.Warnf("Switch %s command failed: %s", s.GetName(), err)
+	}
+}
+
+// HandleCommand switches to the state of payload and publishes the new state
+// if the command function succeeds
+func (s *Switch) HandleCommand(broker MQTT.Client, payload string) error {
+	var state bool
+	switch payload {
+	case orDefault(s.PayloadOn, "ON"):
+		state = true
+	case orDefault(s.PayloadOff, "OFF"):
+		state = false
+	default:
+		return fmt.Errorf("Unknown payload %s", payload)
+	}
+	if s.toggleFunc != nil {
+		err := s.toggleFunc(state)
+		if err != nil {
+			return err
+		}
+	}
+	unlock := lockState(s)
+	defer unlock()
+	s.SetState(state)
+	return s.PublishState(broker)
+}
+
+// RestoreState reads the retained state from the broker, waiting at most
+// timeout, and falls back to DefaultState when nothing is retained. The
+// shared device state is never retained, so it is not restored from.
+func (s *Switch) RestoreState(broker MQTT.Client, timeout time.Duration) error {
+	if s.Device.sharesState(s.StateKey) {
+		s.SetState(s.DefaultState)
+		return nil
+	}
+	received := make(chan string, 1)
+	token := broker.Subscribe(s.GetStateTopic(), 0, func(client MQTT.Client, message MQTT.Message) {
+		if !message.Retained() {
+			return
+		}
+		select {
+		case received <- string(message.Payload()):
+		default:
+		}
+	})
+	token.Wait()
+	if token.Error() != nil {
+		s.SetState(s.DefaultState)
+		return token.Error()
+	}
+	defer broker.Unsubscribe(s.GetStateTopic())
+
+	select {
+	case payload := <-received:
+		switch payload {
+		case orDefault(s.StateOn, "ON"):
+			s.SetState(true)
+		case orDefault(s.StateOff, "OFF"):
+			s.SetState(false)
+		default:
+			s.SetState(s.DefaultState)
+			return fmt.Errorf("Unknown retained state %s", payload)
+		}
+		log.Infof("Restored switch %s state %s", s.GetName(), payload)
+	case <-time.After(timeout):
+		s.SetState(s.DefaultState)
+	}
+	return nil
+}
+
+// stateString returns the state payload of the switch
+func (s *Switch) stateString() string {
+	if s.currentState == true {
+		return orDefault(s.StateOn, "ON")
 	}
+	return orDefault(s.StateOff, "OFF")
 }
 
 // State returns current state
@@ -106,6 +213,25 @@ func (s *Switch) State() bool {
 // SetState sets sensor state
 func (s *Switch) SetState(state bool) {
 	s.currentState = state
+	s.lastStateUpdate = time.Now()
+}
+
+// SaveState stores the current state of the switch
+func (s *Switch) SaveState(store Store) error {
+	return store.Save(storeKey(s), binarySnapshot{State: s.currentState, Updated: s.lastStateUpdate})
+}
+
+// LoadState restores the current state of the switch, returns false when
+// nothing was stored
+func (s *Switch) LoadState(store Store) (bool, error) {
+	var snapshot binarySnapshot
+	ok, err := store.Load(storeKey(s), &snapshot)
+	if !ok || err != nil {
+		return ok, err
+	}
+	s.currentState = snapshot.State
+	s.lastStateUpdate = snapshot.Updated
+	return true, nil
 }
 
 // lastState is the last time the sensor was updates
@@ -121,16 +247,34 @@ func (s *Switch) GetIdent() string {
 	return fmt.Sprintf("%s_%s", s.Device.Ident, s.Ident)
 }
 
+// GetPlatform returns the Home Assistant platform of the switch
+func (s *Switch) GetPlatform() string {
+	return "switch"
+}
+
 // GetBaseTopic for broker
 func (s *Switch) GetBaseTopic() string {
-	return fmt.Sprintf("homeassistant/switch/%s", s.GetIdent())
+	return fmt.Sprintf("homeassistant/%s/%s", s.GetPlatform(), s.GetIdent())
 }
 
 // GetStateTopic returns state topic
 func (s *Switch) GetStateTopic() string {
+	if s.Device.sharesState(s.StateKey) {
+		return s.Device.GetStateTopic()
+	}
 	return fmt.Sprintf("%s/state", s.GetBaseTopic())
 }
 
+// GetStateKey returns the key of the switch in the shared device state
+func (s *Switch) GetStateKey() string {
+	return s.StateKey
+}
+
+// GetStateValue returns the value of the switch in the shared device state
+func (s *Switch) GetStateValue() interface{} {
+	return s.stateString()
+}
+
 // GetAvailabilityTopic returns availability topic
 func (s *Switch) GetAvailabilityTopic() string {
 	if s.Device == nil {
@@ -139,8 +283,27 @@ func (s *Switch) GetAvailabilityTopic() string {
 	return s.Device.GetAvailabilityTopic()
 }
 
+// GetEntityAvailabilityTopic returns the availability topic of the switch itself
+func (s *Switch) GetEntityAvailabilityTopic() string {
+	return fmt.Sprintf("%s/availability", s.GetBaseTopic())
+}
+
+// PublishAvailable send switch availability message to broker
+func (s *Switch) PublishAvailable(broker MQTT.Client) error {
+	return publishAvailability(broker, s.GetName(), s.GetEntityAvailabilityTopic(), payloadAvailable)
+}
+
+// PublishUnavailable send switch unavailability message to broker
+func (s *Switch) PublishUnavailable(broker MQTT.Client) error {
+	return publishAvailability(broker, s.GetName(), s.GetEntityAvailabilityTopic(), payloadNotAvailable)
+}
+
 // PublishDiscover publish discover payload to MQTT
 func (s *Switch) PublishDiscover(broker MQTT.Client) error {
+	err := s.Validate()
+	if err != nil {
+		return err
+	}
 	payload, err := s.GetDiscoverPayload()
 	if err != nil {
 		return err
@@ -152,6 +315,29 @@ func (s *Switch) PublishDiscover(broker MQTT.Client) error {
 	return nil
 }
 
+// Validate checks the device class, that on and off payloads and states
+// differ and that a retained state isn't shared with the device
+func (s *Switch) Validate() error {
+	var v validator
+	v.required("ident", s.Ident)
+	v.oneOf("device_class", s.DeviceClass, switchDeviceClasses)
+	v.topic("state topic", s.GetStateTopic())
+	v.topic("discover topic", s.GetDiscoverTopic())
+	v.topic("availability topic", s.GetAvailabilityTopic())
+	v.availability(s.Availability, s.AvailabilityMode)
+	v.topic("command topic", s.GetCommandTopic())
+	if orDefault(s.PayloadOn, "ON") == orDefault(s.PayloadOff, "OFF") {
+		v.addf("payload_on and payload_off are both %q", orDefault(s.PayloadOn, "ON"))
+	}
+	if orDefault(s.StateOn, "ON") == orDefault(s.StateOff, "OFF") {
+		v.addf("state_on and state_off are both %q", orDefault(s.StateOn, "ON"))
+	}
+	if s.RetainState && s.Device.sharesState(s.StateKey) {
+		v.addf("retain_state can not be used with the shared device state %q", s.StateKey)
+	}
+	return v.err(s.GetName())
+}
+
 // GetDiscoverTopic returns discover topic
 func (s *Switch) GetDiscoverTopic() string {
 	return fmt.Sprintf("%s/config", s.GetBaseTopic())
@@ -164,21 +350,44 @@ func (s *Switch) GetCommandTopic() string {
 
 // GetDiscoverPayload generates disover payload json
 func (s *Switch) GetDiscoverPayload() ([]byte, error) {
+	availabilityTopic, availability, availabilityMode := discoverAvailability(s, s.GetEntityAvailabilityTopic(), s.EntityAvailability, s.Availability, s.AvailabilityMode)
 	return json.Marshal(&struct {
-		UniqueID          string `json:"unique_id"`
-		Name              string `json:"name"`
-		StateTopic        string `json:"stat_t"`
-		AvailabilityTopic string `json:"avty_t,omitempty"`
-		CommandTopic      string `json:"command_topic,omitempty"`
-		Icon              string `json:"icon,omitempty"`
-		Device            Device `json:"device,omitempty"`
+		UniqueID          string         `json:"unique_id"`
+		Name              string         `json:"name"`
+		StateTopic        string         `json:"stat_t"`
+		ValueTemplate     string         `json:"val_tpl,omitempty"`
+		AvailabilityTopic string         `json:"avty_t,omitempty"`
+		Availability      []Availability `json:"avty,omitempty"`
+		AvailabilityMode  string         `json:"avty_mode,omitempty"`
+		CommandTopic      string         `json:"command_topic,omitempty"`
+		CommandTemplate   string         `json:"cmd_tpl,omitempty"`
+		Optimistic        bool           `json:"opt,omitempty"`
+		PayloadOn         string         `json:"pl_on,omitempty"`
+		PayloadOff        string         `json:"pl_off,omitempty"`
+		StateOn           string         `json:"stat_on,omitempty"`
+		StateOff          string         `json:"stat_off,omitempty"`
+		Icon              string         `json:"icon,omitempty"`
+		DeviceClass       string         `json:"dev_cla,omitempty"`
+		Device            *Device        `json:"device,omitempty"`
+		Origin            Origin         `json:"o"`
 	}{
 		UniqueID:          s.GetIdent(),
 		Name:              s.GetName(),
 		StateTopic:        s.GetStateTopic(),
-		AvailabilityTopic: s.GetAvailabilityTopic(),
+		ValueTemplate:     orDefault(s.ValueTemplate, s.Device.stateValueTemplate(s.StateKey)),
+		AvailabilityTopic: availabilityTopic,
+		Availability:      availability,
+		AvailabilityMode:  availabilityMode,
 		CommandTopic:      s.GetCommandTopic(),
+		CommandTemplate:   s.CommandTemplate,
+		Optimistic:        s.Optimistic,
+		PayloadOn:         s.PayloadOn,
+		PayloadOff:        s.PayloadOff,
+		StateOn:           s.StateOn,
+		StateOff:          s.StateOff,
 		Icon:              s.Icon,
-		Device:            *s.Device,
+		DeviceClass:       s.DeviceClass,
+		Device:            s.Device,
+		Origin:            componentOrigin(s),
 	})
 }
@@ -0,0 +1,151 @@
+package homeassistant
+
+import (
+	"encoding/json"
+	"fmt"
+
+	MQTT "github.com/eclipse/paho.mqtt.golang"
+	log "github.com/sirupsen/logrus"
+)
+
+// Tag HA tag scanner, for example a NFC or RFID reader
+type Tag struct {
+	managed
+	Ident         string
+	Name          string
+	Device        *Device
+	ValueTemplate string
+}
+
+// NewTag creates a new tag scanner with default values
+func NewTag(ident string) Tag {
+	t := Tag{
+		Ident: ident,
+	}
+	return t
+}
+
+// GetDevice of tag scanner
+func (t *Tag) GetDevice() *Device {
+	return t.Device
+}
+
+// SetDevice of tag scanner
+func (t *Tag) SetDevice(device *Device) {
+	t.Device = device
+}
+
+// GetName of the tag scanner
+func (t *Tag) GetName() string {
+	var name string
+	if t.Name == "" {
+		name = t.Ident
+	} else {
+		name = t.Name
+	}
+	if t.Device != nil {
+		return fmt.Sprintf("%s %s", t.Device.Name, name)
+	}
+	return name
+}
+
+// GetIdent of the tag scanner
+func (t *Tag) GetIdent() string {
+	if t.Device == nil {
+		return t.Ident
+	}
+	return fmt.Sprintf("%s_%s", t.Device.Ident, t.Ident)
+}
+
+// GetPlatform returns the Home Assistant platform of the tag scanner
+func (t *Tag) GetPlatform() string {
+	return "tag"
+}
+
+// GetBaseTopic for broker
+func (t *Tag) GetBaseTopic() string {
+	return fmt.Sprintf("homeassistant/%s/%s", t.GetPlatform(), t.GetIdent())
+}
+
+// GetStateTopic returns the topic scanned tags are published on
+func (t *Tag) GetStateTopic() string {
+	return fmt.Sprintf("%s/state", t.GetBaseTopic())
+}
+
+// GetAvailabilityTopic returns availability topic, tag scanners don't use it
+// in their discover payload
+func (t *Tag) GetAvailabilityTopic() string {
+	if t.Device == nil {
+		return fmt.Sprintf("%s/availability", t.GetBaseTopic())
+	}
+	return t.Device.GetAvailabilityTopic()
+}
+
+// PublishState does nothing, tag scanners have no state, use Scanned
+func (t *Tag) PublishState(broker MQTT.Client) error {
+	return nil
+}
+
+// Scanned publishes the id of a scanned tag
+func (t *Tag) Scanned(broker MQTT.Client, tagID string) error {
+	token := broker.Publish(t.GetStateTopic(), 0, false, tagID)
+	log.Debugf("Tag %s scanned by %s", tagID, t.GetName())
+	token.Wait()
+	return token.Error()
+}
+
+// ScannedPayload publishes a pre-encoded scan payload, the ValueTemplate
+// extracts the tag id from it
+func (t *Tag) ScannedPayload(broker MQTT.Client, payload PayloadEncoder) error {
+	return publishEncoded(broker, t.GetStateTopic(), false, payload)
+}
+
+// Validate checks the tag scanner belongs to a device, Home Assistant only
+// accepts scanners attached to one
+func (t *Tag) Validate() error {
+	var v validator
+	v.required("ident", t.Ident)
+	if t.Device == nil {
+		v.addf("device is required")
+	}
+	v.topic("topic", t.GetStateTopic())
+	v.topic("discover topic", t.GetDiscoverTopic())
+	return v.err(t.GetName())
+}
+
+// PublishDiscover publish discover payload to MQTT
+func (t *Tag) PublishDiscover(broker MQTT.Client) error {
+	err := t.Validate()
+	if err != nil {
+		return err
+	}
+	payload, err := t.GetDiscoverPayload()
+	if err != nil {
+		return err
+	}
+	token := broker.Publish(t.GetDiscoverTopic(), 0, true, payload)
+	log.Infof("Publishing tag scanner %s discovery to %s", t.GetName(), t.GetDiscoverTopic())
+	log.Debug(string(payload))
+	token.Wait()
+	return nil
+}
+
+// GetDiscoverTopic returns discover topic
+func (t *Tag) GetDiscoverTopic() string {
+	return fmt.Sprintf("%s/config", t.GetBaseTopic())
+}
+
+// GetDiscoverPayload generates discover payload json
+func (t *Tag) GetDiscoverPayload() ([]byte, error) {
+	return json.Marshal(&struct {
+		Topic         string  `json:"t"`
+		ValueTemplate string  `json:"val_tpl,omitempty"`
+		Device        *Device `json:"device,omitempty"`
+		Origin        Origin  `json:"o"`
+	}{
+		Topic:         t.GetStateTopic(),
+		ValueTemplate: t.ValueTemplate,
+		Device:        t.Device,
+		Origin:        componentOrigin(t),
+	})
+}
@@ -0,0 +1,293 @@
+package homeassistant
+
+import (
+	"encoding/json"
+	"fmt"
+
+	MQTT "github.com/eclipse/paho.mqtt.golang"
+	log "github.com/sirupsen/logrus"
+)
+
+// UpdateState is the json state of an Update
+type UpdateState struct {
+	InstalledVersion string `json:"installed_version"`
+	LatestVersion    string `json:"latest_version"`
+	Title            string `json:"title,omitempty"`
+	ReleaseSummary   string `json:"release_summary,omitempty"`
+	ReleaseURL       string `json:"release_url,omitempty"`
+	InProgress       bool   `json:"in_progress"`
+	UpdatePercentage *int   `json:"update_percentage"`
+}
+
+// UpdateInstallFunc installs the latest version, progress reports the
+// percentage done back to Home Assistant
+type UpdateInstallFunc func(latestVersion string, progress func(percentage int)) error
+
+// Update HA update entity reporting firmware versions and installing updates
+type Update struct {
+	managed
+	Ident          string
+	Name           string
+	Device         *Device
+	DeviceClass    string
+	Icon           string
+	PayloadInstall string
+	// Installable adds the install command topic to the discover payload,
+	// subscribe to it with SubscribeCommand
+	Installable        bool
+	EntityAvailability bool
+	Availability       []Availability
+	AvailabilityMode   string
+	mu                 lazyMutex
+	currentState       UpdateState
+	installFunc        UpdateInstallFunc
+}
+
+// NewUpdate creates a new update entity for firmware
+func NewUpdate(ident string) Update {
+	u := Update{
+		Ident:          ident,
+		DeviceClass:    "firmware",
+		PayloadInstall: "install",
+	}
+	return u
+}
+
+// State returns current state
+func (u *Update) State() UpdateState {
+	unlock := u.mu.lock()
+	defer unlock()
+	return u.currentState
+}
+
+// SetState sets the update state
+func (u *Update) SetState(state UpdateState) {
+	unlock := u.mu.lock()
+	defer unlock()
+	u.currentState = state
+}
+
+// SetVersions sets the installed and latest version
+func (u *Update) SetVersions(installed string, latest string) {
+	unlock := u.mu.lock()
+	defer unlock()
+	u.currentState.InstalledVersion = installed
+	u.currentState.LatestVersion = latest
+}
+
+// PublishState publishes the json state to broker
+func (u *Update) PublishState(broker MQTT.Client) error {
+	return publishEncoded(broker, u.GetStateTopic(), true, JSONPayload{Value: u.State()})
+}
+
+// GetCommandTopic returns the command topic
+func (u *Update) GetCommandTopic() string {
+	return fmt.Sprintf("%s/command", u.GetBaseTopic())
+}
+
+// SubscribeCommand subscribe to install commands, set Installable to show the
+// install button in Home Assistant
+func (u *Update) SubscribeCommand(broker MQTT.Client, function UpdateInstallFunc) error {
+	u.installFunc = function
+	token := broker.Subscribe(u.GetCommandTopic(), 0, u.CommandReceived)
+	token.Wait()
+	return token.Error()
+}
+
+// CommandReceived when getting a message from topic
+func (u *Update) CommandReceived(broker MQTT.Client, message MQTT.Message) {
+	err := u.HandleCommand(broker, string(message.Payload()))
+	if err != nil {
+		log.Warnf("Update %s install not started: %s", u.GetName(), err)
+	}
+}
+
+// HandleCommand starts installing the latest version, the install function
+// runs in the background reporting progress and the new installed version
+// when it succeeds
+func (u *Update) HandleCommand(broker MQTT.Client, payload string) error {
+	if payload != orDefault(u.PayloadInstall, "install") {
+		return fmt.Errorf("Unknown payload %s", payload)
+	}
+	if u.installFunc == nil {
+		return fmt.Errorf("No install function for %s", u.GetName())
+	}
+	unlock := u.mu.lock()
+	if u.currentState.InProgress {
+		unlock()
+		return fmt.Errorf("Update of %s already in progress", u.GetName())
+	}
+	latest := u.currentState.LatestVersion
+	u.currentState.InProgress = true
+	u.currentState.UpdatePercentage = nil
+	unlock()
+	err := u.PublishState(broker)
+	go u.install(broker, u.installFunc, latest)
+	return err
+}
+
+// install runs the install function and publishes the result
+func (u *Update) install(broker MQTT.Client, installFunc UpdateInstallFunc, latest string) {
+	err := installFunc(latest, func(percentage int) {
+		unlock := u.mu.lock()
+		u.currentState.UpdatePercentage = &percentage
+		unlock()
+		u.PublishState(broker)
+	})
+	unlock := u.mu.lock()
+	u.currentState.InProgress = false
+	u.currentState.UpdatePercentage = nil
+	if err == nil {
+		u.currentState.InstalledVersion = latest
+	}
+	unlock()
+	if err != nil {
+		log.Warnf("Update %s install failed: %s", u.GetName(), err)
+	}
+	err = u.PublishState(broker)
+	if err != nil {
+		log.Warnf("Update %s state not published: %s", u.GetName(), err)
+	}
+}
+
+// Validate checks the update ident, topics and that firmware is the only
+// device class used
+func (u *Update) Validate() error {
+	var v validator
+	v.required("ident", u.Ident)
+	v.oneOf("device_class", u.DeviceClass, []string{"firmware"})
+	v.topic("state topic", u.GetStateTopic())
+	v.topic("command topic", u.GetCommandTopic())
+	v.topic("discover topic", u.GetDiscoverTopic())
+	v.topic("availability topic", u.GetAvailabilityTopic())
+	v.availability(u.Availability, u.AvailabilityMode)
+	return v.err(u.GetName())
+}
+
+// GetDiscoverPayload generates discover payload json
+func (u *Update) GetDiscoverPayload() ([]byte, error) {
+	availabilityTopic, availability, availabilityMode := discoverAvailability(u, u.GetEntityAvailabilityTopic(), u.EntityAvailability, u.Availability, u.AvailabilityMode)
+	var commandTopic string
+	if u.Installable {
+		commandTopic = u.GetCommandTopic()
+	}
+	return json.Marshal(&struct {
+		UniqueID          string         `json:"unique_id"`
+		Name              string         `json:"name"`
+		StateTopic        string         `json:"stat_t"`
+		CommandTopic      string         `json:"cmd_t,omitempty"`
+		PayloadInstall    string         `json:"pl_inst,omitempty"`
+		AvailabilityTopic string         `json:"avty_t,omitempty"`
+		Availability      []Availability `json:"avty,omitempty"`
+		AvailabilityMode  string         `json:"avty_mode,omitempty"`
+		Icon              string         `json:"icon,omitempty"`
+		DeviceClass       string         `json:"dev_cla,omitempty"`
+		Device            *Device        `json:"device,omitempty"`
+		Origin            Origin         `json:"o"`
+	}{
+		UniqueID:          u.GetIdent(),
+		Name:              u.GetName(),
+		StateTopic:        u.GetStateTopic(),
+		CommandTopic:      commandTopic,
+		PayloadInstall:    u.PayloadInstall,
+		AvailabilityTopic: availabilityTopic,
+		Availability:      availability,
+		AvailabilityMode:  availabilityMode,
+		Icon:              u.Icon,
+		DeviceClass:       u.DeviceClass,
+		Device:            u.Device,
+		Origin:            componentOrigin(u),
+	})
+}
+
+// GetDevice of update
+func (u *Update) GetDevice() *Device {
+	return u.Device
+}
+
+// SetDevice of update
+func (u *Update) SetDevice(device *Device) {
+	u.Device = device
+}
+
+// GetName of the update
+func (u *Update) GetName() string {
+	var name string
+	if u.Name == "" {
+		name = u.Ident
+	} else {
+		name = u.Name
+	}
+	if u.Device != nil {
+		return fmt.Sprintf("%s %s", u.Device.Name, name)
+	}
+	return name
+}
+
+// GetIdent of the update
+func (u *Update) GetIdent() string {
+	if u.Device == nil {
+		return u.Ident
+	}
+	return fmt.Sprintf("%s_%s", u.Device.Ident, u.Ident)
+}
+
+// GetPlatform returns the Home Assistant platform of the update
+func (u *Update) GetPlatform() string {
+	return "update"
+}
+
+// GetBaseTopic for broker
+func (u *Update) GetBaseTopic() string {
+	return fmt.Sprintf("homeassistant/%s/%s", u.GetPlatform(), u.GetIdent())
+}
+
+// GetStateTopic returns state topic
+func (u *Update) GetStateTopic() string {
+	return fmt.Sprintf("%s/state", u.GetBaseTopic())
+}
+
+// GetAvailabilityTopic returns availability topic
+func (u *Update) GetAvailabilityTopic() string {
+	if u.Device == nil {
+		return fmt.Sprintf("%s/availability", u.GetBaseTopic())
+	}
+	return u.Device.GetAvailabilityTopic()
+}
+
+// GetEntityAvailabilityTopic returns the availability topic of the update itself
+func (u *Update) GetEntityAvailabilityTopic() string {
+	return fmt.Sprintf("%s/availability", u.GetBaseTopic())
+}
+
+// PublishAvailable send update availability message to broker
+func (u *Update) PublishAvailable(broker MQTT.Client) error {
+	return publishAvailability(broker, u.GetName(), u.GetEntityAvailabilityTopic(), payloadAvailable)
+}
+
+// PublishUnavailable send update unavailability message to broker
+func (u *Update) PublishUnavailable(broker MQTT.Client) error {
+	return publishAvailability(broker, u.GetName(), u.GetEntityAvailabilityTopic(), payloadNotAvailable)
+}
+
+// PublishDiscover publish discover payload to MQTT
+func (u *Update) PublishDiscover(broker MQTT.Client) error {
+	err := u.Validate()
+	if err != nil {
+		return err
+	}
+	payload, err := u.GetDiscoverPayload()
+	if err != nil {
+		return err
+	}
+	token := broker.Publish(u.GetDiscoverTopic(), 0, true, payload)
+	log.Infof("Publishing update %s discovery to %s", u.GetName(), u.GetDiscoverTopic())
+	log.Debug(string(payload))
+	token.Wait()
+	return nil
+}
+
+// GetDiscoverTopic returns discover topic
+func (u *Update) GetDiscoverTopic() string {
+	return fmt.Sprintf("%s/config", u.GetBaseTopic())
+}
@@ -0,0 +1,342 @@
+package homeassistant
+
+import (
+	"encoding/json"
+	"fmt"
+
+	MQTT "github.com/eclipse/paho.mqtt.golang"
+	log "github.com/sirupsen/logrus"
+)
+
+// Vacuum states
+const (
+	VacuumCleaning  = "cleaning"
+	VacuumDocked    = "docked"
+	VacuumPaused    = "paused"
+	VacuumIdle      = "idle"
+	VacuumReturning = "returning"
+	VacuumError     = "error"
+)
+
+// Vacuum commands
+const (
+	VacuumStart        = "start"
+	VacuumPause        = "pause"
+	VacuumStop         = "stop"
+	VacuumReturnToBase = "return_to_base"
+	VacuumLocate       = "locate"
+	VacuumCleanSpot    = "clean_spot"
+	VacuumSetFanSpeed  = "set_fan_speed"
+)
+
+var vacuumFeatures = []string{
+	"start", "stop", "pause", "return_home", "battery", "status", "locate",
+	"clean_spot", "fan_speed",
+}
+
+// VacuumCommand is a command received from Home Assistant, FanSpeed is only
+// set for VacuumSetFanSpeed
+type VacuumCommand struct {
+	Command  string
+	FanSpeed string
+}
+
+// VacuumCommandFunc forwards the command to the robot, the vacuum keeps its
+// reported status and fan speed when it returns an error
+type VacuumCommandFunc func(command VacuumCommand) error
+
+// VacuumState is the json state of a Vacuum
+type VacuumState struct {
+	State        string `json:"state"`
+	BatteryLevel *int   `json:"battery_level,omitempty"`
+	FanSpeed     string `json:"fan_speed,omitempty"`
+}
+
+// Vacuum HA vacuum cleaner robot
+type Vacuum struct {
+	managed
+	Ident              string
+	Name               string
+	Device             *Device
+	Icon               string
+	SupportedFeatures  []string
+	FanSpeeds          []string
+	EntityAvailability bool
+	Availability       []Availability
+	AvailabilityMode   string
+	currentState       VacuumState
+	commandFunc        VacuumCommandFunc
+}
+
+// NewVacuum creates a new docked vacuum supporting every command but fan speeds
+func NewVacuum(ident string) Vacuum {
+	v := Vacuum{
+		Ident:             ident,
+		SupportedFeatures: []string{"start", "stop", "pause", "return_home", "battery", "status", "locate", "clean_spot"},
+		currentState:      VacuumState{State: VacuumDocked},
+	}
+	return v
+}
+
+// State returns current state
+func (v *Vacuum) State() VacuumState {
+	return v.currentState
+}
+
+// SetState sets the vacuum state
+func (v *Vacuum) SetState(state VacuumState) {
+	v.currentState = state
+}
+
+// SetStatus sets the state, for example cleaning or docked
+func (v *Vacuum) SetStatus(status string) {
+	v.currentState.State = status
+}
+
+// SetBatteryLevel sets the battery level in percent
+func (v *Vacuum) SetBatteryLevel(level int) {
+	v.currentState.BatteryLevel = &level
+}
+
+// PublishState publishes the json state to broker
+func (v *Vacuum) PublishState(broker MQTT.Client) error {
+	return publishEncoded(broker, v.GetStateTopic(), false, JSONPayload{Value: v.currentState})
+}
+
+// GetCommandTopic returns the command topic
+func (v *Vacuum) GetCommandTopic() string {
+	return fmt.Sprintf("%s/command", v.GetBaseTopic())
+}
+
+// GetFanSpeedCommandTopic returns the fan speed command topic
+func (v *Vacuum) GetFanSpeedCommandTopic() string {
+	return fmt.Sprintf("%s/fan_speed/command", v.GetBaseTopic())
+}
+
+// features returns the supported features, with fan_speed when fan speeds are set
+func (v *Vacuum) features() []string {
+	if len(v.FanSpeeds) == 0 || containsString(v.SupportedFeatures, "fan_speed") {
+		return v.SupportedFeatures
+	}
+	features := make([]string, len(v.SupportedFeatures), len(v.SupportedFeatures)+1)
+	copy(features, v.SupportedFeatures)
+	return append(features, "fan_speed")
+}
+
+// SubscribeCommand subscribe to the command channels
+func (v *Vacuum) SubscribeCommand(broker MQTT.Client, function VacuumCommandFunc) error {
+	v.commandFunc = function
+	topics := []string{v.GetCommandTopic()}
+	if len(v.FanSpeeds) > 0 {
+		topics = append(topics, v.GetFanSpeedCommandTopic())
+	}
+	for _, topic := range topics {
+		token := broker.Subscribe(topic, 0, v.CommandReceived)
+		token.Wait()
+		if token.Error() != nil {
+			return token.Error()
+		}
+	}
+	return nil
+}
+
+// CommandReceived when getting a message from topic
+func (v *Vacuum) CommandReceived(broker MQTT.Client, message MQTT.Message) {
+	err := v.HandleCommand(broker, message.Topic(), string(message.Payload()))
+	if err != nil {
+		log.Warnf("Vacuum %s command failed: %s", v.GetName(), err)
+	}
+}
+
+// HandleCommand applies a command received on topic and publishes the new
+// state if the command function succeeds
+func (v *Vacuum) HandleCommand(broker MQTT.Client, topic string, payload string) error {
+	var command VacuumCommand
+	switch topic {
+	case v.GetCommandTopic():
+		switch payload {
+		case VacuumStart, VacuumPause, VacuumStop, VacuumReturnToBase, VacuumLocate, VacuumCleanSpot:
+			command.Command = payload
+		default:
+			return fmt.Errorf("Unknown payload %s", payload)
+		}
+	case v.GetFanSpeedCommandTopic():
+		if !containsString(v.FanSpeeds, payload) {
+			return fmt.Errorf("Unknown fan speed %s", payload)
+		}
+		command = VacuumCommand{Command: VacuumSetFanSpeed, FanSpeed: payload}
+	default:
+		return fmt.Errorf("Unknown command topic %s", topic)
+	}
+	if v.commandFunc != nil {
+		err := v.commandFunc(command)
+		if err != nil {
+			return err
+		}
+	}
+	unlock := lockState(v)
+	defer unlock()
+	switch command.Command {
+	case VacuumStart, VacuumCleanSpot:
+		v.currentState.State = VacuumCleaning
+	case VacuumPause:
+		v.currentState.State = VacuumPaused
+	case VacuumStop:
+		v.currentState.State = VacuumIdle
+	case VacuumReturnToBase:
+		v.currentState.State = VacuumReturning
+	case VacuumSetFanSpeed:
+		v.currentState.FanSpeed = command.FanSpeed
+	}
+	return v.PublishState(broker)
+}
+
+// Validate checks the supported features and that the fan_speed feature comes
+// with fan speeds to choose from
+func (v *Vacuum) Validate() error {
+	var val validator
+	val.required("ident", v.Ident)
+	for _, feature := range v.SupportedFeatures {
+		val.oneOf("supported_features", feature, vacuumFeatures)
+	}
+	if containsString(v.SupportedFeatures, "fan_speed") && len(v.FanSpeeds) == 0 {
+		val.addf("fan_speed_list is required for the fan_speed feature")
+	}
+	val.topic("state topic", v.GetStateTopic())
+	val.topic("command topic", v.GetCommandTopic())
+	val.topic("fan speed command topic", v.GetFanSpeedCommandTopic())
+	val.topic("discover topic", v.GetDiscoverTopic())
+	val.topic("availability topic", v.GetAvailabilityTopic())
+	val.availability(v.Availability, v.AvailabilityMode)
+	return val.err(v.GetName())
+}
+
+// GetDiscoverPayload generates discover payload json
+func (v *Vacuum) GetDiscoverPayload() ([]byte, error) {
+	availabilityTopic, availability, availabilityMode := discoverAvailability(v, v.GetEntityAvailabilityTopic(), v.EntityAvailability, v.Availability, v.AvailabilityMode)
+	var fanSpeedTopic string
+	if len(v.FanSpeeds) > 0 {
+		fanSpeedTopic = v.GetFanSpeedCommandTopic()
+	}
+	return json.Marshal(&struct {
+		UniqueID          string         `json:"unique_id"`
+		Name              string         `json:"name"`
+		StateTopic        string         `json:"stat_t"`
+		CommandTopic      string         `json:"cmd_t"`
+		FanSpeedTopic     string         `json:"set_fan_spd_t,omitempty"`
+		FanSpeeds         []string       `json:"fanspd_lst,omitempty"`
+		SupportedFeatures []string       `json:"sup_feat"`
+		AvailabilityTopic string         `json:"avty_t,omitempty"`
+		Availability      []Availability `json:"avty,omitempty"`
+		AvailabilityMode  string         `json:"avty_mode,omitempty"`
+		Icon              string         `json:"icon,omitempty"`
+		Device            *Device        `json:"device,omitempty"`
+		Origin            Origin         `json:"o"`
+	}{
+		UniqueID:          v.GetIdent(),
+		Name:              v.GetName(),
+		StateTopic:        v.GetStateTopic(),
+		CommandTopic:      v.GetCommandTopic(),
+		FanSpeedTopic:     fanSpeedTopic,
+		FanSpeeds:         v.FanSpeeds,
+		SupportedFeatures: v.features(),
+		AvailabilityTopic: availabilityTopic,
+		Availability:      availability,
+		AvailabilityMode:  availabilityMode,
+		Icon:              v.Icon,
+		Device:            v.Device,
+		Origin:            componentOrigin(v),
+	})
+}
+
+// GetDevice of vacuum
+func (v *Vacuum) GetDevice() *Device {
+	return v.Device
+}
+
+// SetDevice of vacuum
+func (v *Vacuum) SetDevice(device *Device) {
+	v.Device = device
+}
+
+// GetName of the vacuum
+func (v *Vacuum) GetName() string {
+	var name string
+	if v.Name == "" {
+		name = v.Ident
+	} else {
+		name = v.Name
+	}
+	if v.Device != nil {
+		return fmt.Sprintf("%s %s", v.Device.Name, name)
+	}
+	return name
+}
+
+// GetIdent of the vacuum
+func (v *Vacuum) GetIdent() string {
+	if v.Device == nil {
+		return v.Ident
+	}
+	return fmt.Sprintf("%s_%s", v.Device.Ident, v.Ident)
+}
+
+// GetPlatform returns the Home Assistant platform of the vacuum
+func (v *Vacuum) GetPlatform() string {
+	return "vacuum"
+}
+
+// GetBaseTopic for broker
+func (v *Vacuum) GetBaseTopic() string {
+	return fmt.Sprintf("homeassistant/%s/%s", v.GetPlatform(), v.GetIdent())
+}
+
+// GetStateTopic returns state topic
+func (v *Vacuum) GetStateTopic() string {
+	return fmt.Sprintf("%s/state", v.GetBaseTopic())
+}
+
+// GetAvailabilityTopic returns availability topic
+func (v *Vacuum) GetAvailabilityTopic() string {
+	if v.Device == nil {
+		return fmt.Sprintf("%s/availability", v.GetBaseTopic())
+	}
+	return v.Device.GetAvailabilityTopic()
+}
+
+// GetEntityAvailabilityTopic returns the availability topic of the vacuum itself
+func (v *Vacuum) GetEntityAvailabilityTopic() string {
+	return fmt.Sprintf("%s/availability", v.GetBaseTopic())
+}
+
+// PublishAvailable send vacuum availability message to broker
+func (v *Vacuum) PublishAvailable(broker MQTT.Client) error {
+	return publishAvailability(broker, v.GetName(), v.GetEntityAvailabilityTopic(), payloadAvailable)
+}
+
+// PublishUnavailable send vacuum unavailability message to broker
+func (v *Vacuum) PublishUnavailable(broker MQTT.Client) error {
+	return publishAvailability(broker, v.GetName(), v.GetEntityAvailabilityTopic(), payloadNotAvailable)
+}
+
+// PublishDiscover publish discover payload to MQTT
+func (v *Vacuum) PublishDiscover(broker MQTT.Client) error {
+	err := v.Validate()
+	if err != nil {
+		return err
+	}
+	payload, err := v.GetDiscoverPayload()
+	if err != nil {
+		return err
+	}
+	token := broker.Publish(v.GetDiscoverTopic(), 0, true, payload)
+	log.Infof("Publishing vacuum %s discovery to %s", v.GetName(), v.GetDiscoverTopic())
+	log.Debug(string(payload))
+	token.Wait()
+	return nil
+}
+
+// GetDiscoverTopic returns discover topic
+func (v *Vacuum) GetDiscoverTopic() string {
+	return fmt.Sprintf("%s/config", v.GetBaseTopic())
+}
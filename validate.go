@@ -0,0 +1,189 @@
+package homeassistant
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ValidationError lists everything wrong with the configuration of a component
+type ValidationError struct {
+	Component string
+	Errors    []error
+}
+
+func (e *ValidationError) Error() string {
+	messages := make([]string, len(e.Errors))
+	for i, err := range e.Errors {
+		messages[i] = err.Error()
+	}
+	return fmt.Sprintf("Invalid %s: %s", e.Component, strings.Join(messages, "; "))
+}
+
+// validator collects validation errors for a component
+type validator struct {
+	errors []error
+}
+
+func (v *validator) addf(format string, args ...interface{}) {
+	v.errors = append(v.errors, fmt.Errorf(format, args...))
+}
+
+// err returns a ValidationError for component, nil when there are no errors
+func (v *validator) err(component string) error {
+	if len(v.errors) == 0 {
+		return nil
+	}
+	return &ValidationError{Component: component, Errors: v.errors}
+}
+
+// required checks that value is set
+func (v *validator) required(field string, value string) {
+	if value == "" {
+		v.addf("%s is required", field)
+	}
+}
+
+// topic checks that topic can be published to
+func (v *validator) topic(field string, topic string) {
+	switch {
+	case topic == "":
+		v.addf("%s is empty", field)
+	case len(topic) > 65535:
+		v.addf("%s is longer than 65535 bytes", field)
+	case strings.ContainsAny(topic, "+#\x00"):
+		v.addf("%s %q contains wildcard or null characters", field, topic)
+	}
+}
+
+// oneOf checks that value is empty or one of values
+func (v *validator) oneOf(field string, value string, values []string) {
+	if value == "" {
+		return
+	}
+	for _, allowed := range values {
+		if value == allowed {
+			return
+		}
+	}
+	v.addf("%s %q is not one of %s", field, value, strings.Join(values, ", "))
+}
+
+// availability checks the availability configuration of a component
+func (v *validator) availability(entries []Availability, mode string) {
+	for i, a := range entries {
+		v.topic(fmt.Sprintf("availability[%d] topic", i), a.Topic)
+	}
+	v.oneOf("availability_mode", mode, []string{AvailabilityModeAll, AvailabilityModeAny, AvailabilityModeLatest})
+}
+
+var sensorDeviceClasses = []string{
+	"apparent_power", "aqi", "area", "atmospheric_pressure", "battery",
+	"blood_glucose_concentration", "carbon_dioxide", "carbon_monoxide",
+	"conductivity", "current", "data_rate", "data_size", "date", "distance",
+	"duration", "energy", "energy_distance", "energy_storage", "enum",
+	"frequency", "gas", "humidity", "illuminance", "irradiance", "moisture",
+	"monetary", "nitrogen_dioxide", "nitrogen_monoxide", "nitrous_oxide",
+	"ozone", "ph", "pm1", "pm10", "pm25", "power", "power_factor",
+	"precipitation", "precipitation_intensity", "pressure", "reactive_power",
+	"signal_strength", "sound_pressure", "speed", "sulphur_dioxide",
+	"temperature", "timestamp", "volatile_organic_compounds",
+	"volatile_organic_compounds_parts", "voltage", "volume", "volume_flow_rate",
+	"volume_storage", "water", "weight", "wind_direction", "wind_speed",
+}
+
+var binarySensorDeviceClasses = []string{
+	"battery", "battery_charging", "carbon_monoxide", "cold", "connectivity",
+	"door", "garage_door", "gas", "heat", "light", "lock", "moisture", "motion",
+	"moving", "occupancy", "opening", "plug", "power", "presence", "problem",
+	"running", "safety", "smoke", "sound", "tamper", "update", "vibration",
+	"window",
+}
+
+var switchDeviceClasses = []string{"outlet", "switch"}
+
+var stateClasses = []string{"measurement", "measurement_angle", "total", "total_increasing"}
+
+// deviceClassUnits are the units Home Assistant accepts for a sensor device
+// class, device classes not listed aren't checked. Micro units use the greek
+// mu like Home Assistant, the micro sign is accepted as an alias
+var deviceClassUnits = map[string][]string{
+	"apparent_power":       {"VA"},
+	"aqi":                  {""},
+	"atmospheric_pressure": {"cbar", "bar", "hPa", "mmHg", "inHg", "kPa", "mbar", "Pa", "psi"},
+	"battery":              {"%"},
+	"carbon_dioxide":       {"ppm"},
+	"carbon_monoxide":      {"ppm"},
+	"current":              {"A", "mA"},
+	"date":                 {""},
+	"duration":             {"d", "h", "min", "s", "ms"},
+	"energy":               {"Wh", "kWh", "MWh", "GWh", "TWh", "MJ", "GJ"},
+	"enum":                 {""},
+	"frequency":            {"Hz", "kHz", "MHz", "GHz"},
+	"humidity":             {"%"},
+	"illuminance":          {"lx"},
+	"moisture":             {"%"},
+	"ph":                   {""},
+	"pm1":                  {"μg/m³", "µg/m³"},
+	"pm10":                 {"μg/m³", "µg/m³"},
+	"pm25":                 {"μg/m³", "µg/m³"},
+	"power":                {"mW", "W", "kW", "MW", "GW", "TW"},
+	"power_factor":         {"", "%"},
+	"pressure":             {"cbar", "bar", "hPa", "mmHg", "inHg", "kPa", "mbar", "Pa", "psi"},
+	"reactive_power":       {"var"},
+	"signal_strength":      {"dB", "dBm"},
+	"temperature":          {"°C", "°F", "K"},
+	"timestamp":            {""},
+	"voltage":              {"μV", "µV", "mV", "V", "kV", "MV"},
+}
+
+// deviceClassStateClasses are the state classes allowed for a sensor device
+// class, device classes not listed accept every state class
+var deviceClassStateClasses = map[string][]string{
+	"date":      {},
+	"enum":      {},
+	"timestamp": {},
+	"energy":    {"total", "total_increasing"},
+	"gas":       {"total", "total_increasing"},
+	"monetary":  {"total"},
+	"water":     {"total", "total_increasing"},
+}
+
+// unit checks that unit is accepted for the sensor device class
+func (v *validator) unit(deviceClass string, unit string) {
+	units, ok := deviceClassUnits[deviceClass]
+	if !ok {
+		return
+	}
+	for _, u := range units {
+		if u == unit {
+			return
+		}
+	}
+	if unit == "" {
+		v.addf("device_class %s requires unit_of_measurement %s", deviceClass, strings.Join(units, ", "))
+		return
+	}
+	v.addf("unit_of_measurement %q is not valid for device_class %s", unit, deviceClass)
+}
+
+// stateClass checks the state class and its combination with the device class
+func (v *validator) stateClass(deviceClass string, stateClass string) {
+	if stateClass == "" {
+		return
+	}
+	v.oneOf("state_class", stateClass, stateClasses)
+	allowed, ok := deviceClassStateClasses[deviceClass]
+	if !ok {
+		return
+	}
+	for _, c := range allowed {
+		if c == stateClass {
+			return
+		}
+	}
+	if len(allowed) == 0 {
+		v.addf("device_class %s doesn't support a state_class", deviceClass)
+		return
+	}
+	v.addf("state_class %s is not valid for device_class %s", stateClass, deviceClass)
+}
@@ -0,0 +1,325 @@
+package homeassistant
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	MQTT "github.com/eclipse/paho.mqtt.golang"
+	log "github.com/sirupsen/logrus"
+)
+
+// Valve states
+const (
+	ValveOpen    = "open"
+	ValveOpening = "opening"
+	ValveClosed  = "closed"
+	ValveClosing = "closing"
+)
+
+// Valve command actions
+const (
+	ValveActionOpen     = "open"
+	ValveActionClose    = "close"
+	ValveActionStop     = "stop"
+	ValveActionPosition = "position"
+)
+
+// ValveCommand is a command received from Home Assistant, Position is only
+// set for ValveActionPosition
+type ValveCommand struct {
+	Action   string
+	Position int
+}
+
+// ValveCommandFunc moves the valve, the reported position stays where it was
+// when it returns an error
+type ValveCommandFunc func(command ValveCommand) error
+
+// Valve HA valve, optionally reporting its position from 0 (closed) to 100 (open)
+type Valve struct {
+	managed
+	Ident              string
+	Name               string
+	Device             *Device
+	DeviceClass        string
+	Icon               string
+	ReportsPosition    bool
+	Optimistic         bool
+	PayloadOpen        string
+	PayloadClose       string
+	PayloadStop        string
+	EntityAvailability bool
+	Availability       []Availability
+	AvailabilityMode   string
+	currentState       string
+	position           int
+	commandFunc        ValveCommandFunc
+}
+
+// NewValve creates a new closed valve, the payloads default to OPEN, CLOSE and
+// STOP
+func NewValve(ident string) Valve {
+	v := Valve{
+		Ident:        ident,
+		currentState: ValveClosed,
+	}
+	return v
+}
+
+// State returns current state
+func (v *Valve) State() string {
+	return v.currentState
+}
+
+// SetState sets the valve state
+func (v *Valve) SetState(state string) {
+	v.currentState = state
+}
+
+// Position returns current position
+func (v *Valve) Position() int {
+	return v.position
+}
+
+// SetPosition sets the position, and the state when fully open or closed
+func (v *Valve) SetPosition(position int) {
+	v.position = position
+	switch position {
+	case 0:
+		v.currentState = ValveClosed
+	case 100:
+		v.currentState = ValveOpen
+	}
+}
+
+// PublishState publishes the state, with the position when the valve reports it
+func (v *Valve) PublishState(broker MQTT.Client) error {
+	if v.ReportsPosition {
+		return publishEncoded(broker, v.GetStateTopic(), false, JSONPayload{Value: map[string]interface{}{
+			"state":    v.currentState,
+			"position": v.position,
+		}})
+	}
+	token := broker.Publish(v.GetStateTopic(), 0, false, v.currentState)
+	token.Wait()
+	return token.Error()
+}
+
+// GetCommandTopic returns the command topic
+func (v *Valve) GetCommandTopic() string {
+	return fmt.Sprintf("%s/command", v.GetBaseTopic())
+}
+
+// SubscribeCommand subscribe to command channel
+func (v *Valve) SubscribeCommand(broker MQTT.Client, function ValveCommandFunc) error {
+	v.commandFunc = function
+	token := broker.Subscribe(v.GetCommandTopic(), 0, v.CommandReceived)
+	token.Wait()
+	return token.Error()
+}
+
+// CommandReceived when getting a message from topic
+func (v *Valve) CommandReceived(broker MQTT.Client, message MQTT.Message) {
+	err := v.HandleCommand(broker, string(message.Payload()))
+	if err != nil {
+		log.Warnf("Valve %s command failed: %s", v.GetName(), err)
+	}
+}
+
+// HandleCommand moves the valve and publishes the new state if the command
+// function succeeds
+func (v *Valve) HandleCommand(broker MQTT.Client, payload string) error {
+	var command ValveCommand
+	switch payload {
+	case orDefault(v.PayloadOpen, "OPEN"):
+		command.Action = ValveActionOpen
+	case orDefault(v.PayloadClose, "CLOSE"):
+		command.Action = ValveActionClose
+	case orDefault(v.PayloadStop, "STOP"):
+		command.Action = ValveActionStop
+	default:
+		position, err := strconv.Atoi(payload)
+		if err != nil || !v.ReportsPosition || position < 0 || position > 100 {
+			return fmt.Errorf("Unknown payload %s", payload)
+		}
+		command = ValveCommand{Action: ValveActionPosition, Position: position}
+	}
+	if v.commandFunc != nil {
+		err := v.commandFunc(command)
+		if err != nil {
+			return err
+		}
+	}
+	unlock := lockState(v)
+	defer unlock()
+	switch command.Action {
+	case ValveActionOpen:
+		v.SetPosition(100)
+	case ValveActionClose:
+		v.SetPosition(0)
+	case ValveActionStop:
+		if v.position > 0 {
+			v.currentState = ValveOpen
+		}
+	case ValveActionPosition:
+		v.SetPosition(command.Position)
+		if command.Position > 0 && command.Position < 100 {
+			v.currentState = ValveOpen
+		}
+	}
+	return v.PublishState(broker)
+}
+
+// Validate checks the device class and that open and close payloads are not
+// combined with position reporting
+func (v *Valve) Validate() error {
+	var val validator
+	val.required("ident", v.Ident)
+	val.oneOf("device_class", v.DeviceClass, []string{"water", "gas"})
+	if v.ReportsPosition && (v.PayloadOpen != "" || v.PayloadClose != "") {
+		val.addf("payload_open and payload_close can't be used with reports_position")
+	}
+	val.topic("state topic", v.GetStateTopic())
+	val.topic("command topic", v.GetCommandTopic())
+	val.topic("discover topic", v.GetDiscoverTopic())
+	val.topic("availability topic", v.GetAvailabilityTopic())
+	val.availability(v.Availability, v.AvailabilityMode)
+	return val.err(v.GetName())
+}
+
+// GetDiscoverPayload generates discover payload json
+func (v *Valve) GetDiscoverPayload() ([]byte, error) {
+	availabilityTopic, availability, availabilityMode := discoverAvailability(v, v.GetEntityAvailabilityTopic(), v.EntityAvailability, v.Availability, v.AvailabilityMode)
+	payloadOpen, payloadClose := v.PayloadOpen, v.PayloadClose
+	if v.ReportsPosition {
+		payloadOpen, payloadClose = "", ""
+	}
+	return json.Marshal(&struct {
+		UniqueID          string         `json:"unique_id"`
+		Name              string         `json:"name"`
+		StateTopic        string         `json:"stat_t"`
+		CommandTopic      string         `json:"cmd_t"`
+		ReportsPosition   bool           `json:"pos,omitempty"`
+		Optimistic        bool           `json:"opt,omitempty"`
+		PayloadOpen       string         `json:"pl_open,omitempty"`
+		PayloadClose      string         `json:"pl_cls,omitempty"`
+		PayloadStop       string         `json:"pl_stop,omitempty"`
+		AvailabilityTopic string         `json:"avty_t,omitempty"`
+		Availability      []Availability `json:"avty,omitempty"`
+		AvailabilityMode  string         `json:"avty_mode,omitempty"`
+		Icon              string         `json:"icon,omitempty"`
+		DeviceClass       string         `json:"dev_cla,omitempty"`
+		Device            *Device        `json:"device,omitempty"`
+		Origin            Origin         `json:"o"`
+	}{
+		UniqueID:          v.GetIdent(),
+		Name:              v.GetName(),
+		StateTopic:        v.GetStateTopic(),
+		CommandTopic:      v.GetCommandTopic(),
+		ReportsPosition:   v.ReportsPosition,
+		Optimistic:        v.Optimistic,
+		PayloadOpen:       payloadOpen,
+		PayloadClose:      payloadClose,
+		PayloadStop:       v.PayloadStop,
+		AvailabilityTopic: availabilityTopic,
+		Availability:      availability,
+		AvailabilityMode:  availabilityMode,
+		Icon:              v.Icon,
+		DeviceClass:       v.DeviceClass,
+		Device:            v.Device,
+		Origin:            componentOrigin(v),
+	})
+}
+
+// GetDevice of valve
+func (v *Valve) GetDevice() *Device {
+	return v.Device
+}
+
+// SetDevice of valve
+func (v *Valve) SetDevice(device *Device) {
+	v.Device = device
+}
+
+// GetName of the valve
+func (v *Valve) GetName() string {
+	var name string
+	if v.Name == "" {
+		name = v.Ident
+	} else {
+		name = v.Name
+	}
+	if v.Device != nil {
+		return fmt.Sprintf("%s %s", v.Device.Name, name)
+	}
+	return name
+}
+
+// GetIdent of the valve
+func (v *Valve) GetIdent() string {
+	if v.Device == nil {
+		return v.Ident
+	}
+	return fmt.Sprintf("%s_%s", v.Device.Ident, v.Ident)
+}
+
+// GetPlatform returns the Home Assistant platform of the valve
+func (v *Valve) GetPlatform() string {
+	return "valve"
+}
+
+// GetBaseTopic for broker
+func (v *Valve) GetBaseTopic() string {
+	return fmt.Sprintf("homeassistant/%s/%s", v.GetPlatform(), v.GetIdent())
+}
+
+// GetStateTopic returns state topic
+func (v *Valve) GetStateTopic() string {
+	return fmt.Sprintf("%s/state", v.GetBaseTopic())
+}
+
+// GetAvailabilityTopic returns availability topic
+func (v *Valve) GetAvailabilityTopic() string {
+	if v.Device == nil {
+		return fmt.Sprintf("%s/availability", v.GetBaseTopic())
+	}
+	return v.Device.GetAvailabilityTopic()
+}
+
+// GetEntityAvailabilityTopic returns the availability topic of the valve itself
+func (v *Valve) GetEntityAvailabilityTopic() string {
+	return fmt.Sprintf("%s/availability", v.GetBaseTopic())
+}
+
+// PublishAvailable send valve availability message to broker
+func (v *Valve) PublishAvailable(broker MQTT.Client) error {
+	return publishAvailability(broker, v.GetName(), v.GetEntityAvailabilityTopic(), payloadAvailable)
+}
+
+// PublishUnavailable send valve unavailability message to broker
+func (v *Valve) PublishUnavailable(broker MQTT.Client) error {
+	return publishAvailability(broker, v.GetName(), v.GetEntityAvailabilityTopic(), payloadNotAvailable)
+}
+
+// PublishDiscover publish discover payload to MQTT
+func (v *Valve) PublishDiscover(broker MQTT.Client) error {
+	err := v.Validate()
+	if err != nil {
+		return err
+	}
+	payload, err := v.GetDiscoverPayload()
+	if err != nil {
+		return err
+	}
+	token := broker.Publish(v.GetDiscoverTopic(), 0, true, payload)
+	log.Infof("Publishing valve %s discovery to %s", v.GetName(), v.GetDiscoverTopic())
+	log.Debug(string(payload))
+	token.Wait()
+	return nil
+}
+
+// GetDiscoverTopic returns discover topic
+func (v *Valve) GetDiscoverTopic() string {
+	return fmt.Sprintf("%s/config", v.GetBaseTopic())
+}
@@ -0,0 +1,368 @@
+package homeassistant
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	MQTT "github.com/eclipse/paho.mqtt.golang"
+	log "github.com/sirupsen/logrus"
+)
+
+// Water heater modes
+const (
+	WaterHeaterOff         = "off"
+	WaterHeaterEco         = "eco"
+	WaterHeaterElectric    = "electric"
+	WaterHeaterGas         = "gas"
+	WaterHeaterHeatPump    = "heat_pump"
+	WaterHeaterHighDemand  = "high_demand"
+	WaterHeaterPerformance = "performance"
+)
+
+var waterHeaterModes = []string{
+	WaterHeaterOff, WaterHeaterEco, WaterHeaterElectric, WaterHeaterGas,
+	WaterHeaterHeatPump, WaterHeaterHighDemand, WaterHeaterPerformance,
+}
+
+// Water heater command types
+const (
+	WaterHeaterCommandMode        = "mode"
+	WaterHeaterCommandTemperature = "temperature"
+)
+
+// WaterHeaterCommand is a command received from Home Assistant, only the
+// field matching Type is set
+type WaterHeaterCommand struct {
+	Type        string
+	Mode        string
+	Temperature float64
+}
+
+// WaterHeaterCommandFunc sets the operation mode or target temperature, an
+// error keeps the heater at its previous setting
+type WaterHeaterCommandFunc func(command WaterHeaterCommand) error
+
+// WaterHeater HA water heater, for example a boiler, with mode and target
+// temperature
+type WaterHeater struct {
+	managed
+	Ident              string
+	Name               string
+	Device             *Device
+	Icon               string
+	Modes              []string
+	MinTemp            float64
+	MaxTemp            float64
+	TemperatureUnit    string
+	Precision          float64
+	Optimistic         bool
+	EntityAvailability bool
+	Availability       []Availability
+	AvailabilityMode   string
+	mode               string
+	temperature        float64
+	currentTemperature *float64
+	commandFunc        WaterHeaterCommandFunc
+}
+
+// NewWaterHeater creates a new water heater with the Home Assistant default
+// temperature range in celsius
+func NewWaterHeater(ident string) WaterHeater {
+	w := WaterHeater{
+		Ident:           ident,
+		Modes:           []string{WaterHeaterOff, WaterHeaterEco, WaterHeaterElectric},
+		MinTemp:         43.3,
+		MaxTemp:         60,
+		TemperatureUnit: "C",
+		mode:            WaterHeaterOff,
+	}
+	return w
+}
+
+// Mode returns the current mode
+func (w *WaterHeater) Mode() string {
+	return w.mode
+}
+
+// SetMode sets the current mode
+func (w *WaterHeater) SetMode(mode string) {
+	w.mode = mode
+}
+
+// Temperature returns the target temperature
+func (w *WaterHeater) Temperature() float64 {
+	return w.temperature
+}
+
+// SetTemperature sets the target temperature
+func (w *WaterHeater) SetTemperature(temperature float64) {
+	w.temperature = temperature
+}
+
+// SetCurrentTemperature sets the measured temperature
+func (w *WaterHeater) SetCurrentTemperature(temperature float64) {
+	w.currentTemperature = &temperature
+}
+
+// PublishState publishes the mode, target temperature and measured
+// temperature to broker
+func (w *WaterHeater) PublishState(broker MQTT.Client) error {
+	err := publishEncoded(broker, w.GetStateTopic(), false, RawPayload(w.mode))
+	if err != nil {
+		return err
+	}
+	err = publishEncoded(broker, w.GetTemperatureStateTopic(), false, RawPayload(fmt.Sprintf("%.1f", w.temperature)))
+	if err != nil {
+		return err
+	}
+	if w.currentTemperature != nil {
+		return publishEncoded(broker, w.GetCurrentTemperatureTopic(), false, RawPayload(fmt.Sprintf("%.1f", *w.currentTemperature)))
+	}
+	return nil
+}
+
+// GetCommandTopic returns the mode command topic
+func (w *WaterHeater) GetCommandTopic() string {
+	return fmt.Sprintf("%s/command", w.GetBaseTopic())
+}
+
+// GetTemperatureStateTopic returns the target temperature state topic
+func (w *WaterHeater) GetTemperatureStateTopic() string {
+	return fmt.Sprintf("%s/temperature/state", w.GetBaseTopic())
+}
+
+// GetTemperatureCommandTopic returns the target temperature command topic
+func (w *WaterHeater) GetTemperatureCommandTopic() string {
+	return fmt.Sprintf("%s/temperature/command", w.GetBaseTopic())
+}
+
+// GetCurrentTemperatureTopic returns the measured temperature topic
+func (w *WaterHeater) GetCurrentTemperatureTopic() string {
+	return fmt.Sprintf("%s/current_temperature", w.GetBaseTopic())
+}
+
+// SubscribeCommand subscribe to the mode and temperature command channels
+func (w *WaterHeater) SubscribeCommand(broker MQTT.Client, function WaterHeaterCommandFunc) error {
+	w.commandFunc = function
+	for _, topic := range []string{w.GetCommandTopic(), w.GetTemperatureCommandTopic()} {
+		token := broker.Subscribe(topic, 0, w.CommandReceived)
+		token.Wait()
+		if token.Error() != nil {
+			return token.Error()
+		}
+	}
+	return nil
+}
+
+// CommandReceived when getting a message from topic
+func (w *WaterHeater) CommandReceived(broker MQTT.Client, message MQTT.Message) {
+	err := w.HandleCommand(broker, message.Topic(), string(message.Payload()))
+	if err != nil {
+		log.Warnf("Water heater %s command failed: %s", w.GetName(), err)
+	}
+}
+
+// HandleCommand applies a command received on topic and publishes the new
+// state if the command function succeeds
+func (w *WaterHeater) HandleCommand(broker MQTT.Client, topic string, payload string) error {
+	var command WaterHeaterCommand
+	switch topic {
+	case w.GetCommandTopic():
+		if !containsString(w.Modes, payload) {
+			return fmt.Errorf("Unknown mode %s", payload)
+		}
+		command = WaterHeaterCommand{Type: WaterHeaterCommandMode, Mode: payload}
+	case w.GetTemperatureCommandTopic():
+		temperature, err := strconv.ParseFloat(payload, 64)
+		if err != nil {
+			return fmt.Errorf("Unknown payload %s", payload)
+		}
+		if temperature < w.MinTemp || temperature > w.MaxTemp {
+			return fmt.Errorf("Temperature %.1f out of range %.1f-%.1f", temperature, w.MinTemp, w.MaxTemp)
+		}
+		command = WaterHeaterCommand{Type: WaterHeaterCommandTemperature, Temperature: temperature}
+	default:
+		return fmt.Errorf("Unknown command topic %s", topic)
+	}
+	if w.commandFunc != nil {
+		err := w.commandFunc(command)
+		if err != nil {
+			return err
+		}
+	}
+	unlock := lockState(w)
+	defer unlock()
+	switch command.Type {
+	case WaterHeaterCommandMode:
+		w.mode = command.Mode
+	case WaterHeaterCommandTemperature:
+		w.temperature = command.Temperature
+	}
+	return w.PublishState(broker)
+}
+
+// Validate checks the operation modes, the temperature range, unit and
+// precision of the water heater
+func (w *WaterHeater) Validate() error {
+	var v validator
+	v.required("ident", w.Ident)
+	if len(w.Modes) == 0 {
+		v.addf("modes is required")
+	}
+	for _, mode := range w.Modes {
+		v.oneOf("mode", mode, waterHeaterModes)
+	}
+	if w.MinTemp >= w.MaxTemp {
+		v.addf("min_temp %.1f must be lower than max_temp %.1f", w.MinTemp, w.MaxTemp)
+	}
+	v.oneOf("temperature_unit", w.TemperatureUnit, []string{"C", "F"})
+	if w.Precision != 0 && w.Precision != 0.1 && w.Precision != 0.5 && w.Precision != 1 {
+		v.addf("precision %v is not one of 0.1, 0.5, 1.0", w.Precision)
+	}
+	v.topic("state topic", w.GetStateTopic())
+	v.topic("command topic", w.GetCommandTopic())
+	v.topic("temperature command topic", w.GetTemperatureCommandTopic())
+	v.topic("discover topic", w.GetDiscoverTopic())
+	v.topic("availability topic", w.GetAvailabilityTopic())
+	v.availability(w.Availability, w.AvailabilityMode)
+	return v.err(w.GetName())
+}
+
+// GetDiscoverPayload generates discover payload json
+func (w *WaterHeater) GetDiscoverPayload() ([]byte, error) {
+	availabilityTopic, availability, availabilityMode := discoverAvailability(w, w.GetEntityAvailabilityTopic(), w.EntityAvailability, w.Availability, w.AvailabilityMode)
+	return json.Marshal(&struct {
+		UniqueID                string         `json:"unique_id"`
+		Name                    string         `json:"name"`
+		ModeStateTopic          string         `json:"mode_stat_t"`
+		ModeCommandTopic        string         `json:"mode_cmd_t"`
+		TemperatureStateTopic   string         `json:"temp_stat_t"`
+		TemperatureCommandTopic string         `json:"temp_cmd_t"`
+		CurrentTemperatureTopic string         `json:"curr_temp_t"`
+		Modes                   []string       `json:"modes"`
+		MinTemp                 float64        `json:"min_temp"`
+		MaxTemp                 float64        `json:"max_temp"`
+		TemperatureUnit         string         `json:"temp_unit,omitempty"`
+		Precision               float64        `json:"precision,omitempty"`
+		Optimistic              bool           `json:"opt,omitempty"`
+		AvailabilityTopic       string         `json:"avty_t,omitempty"`
+		Availability            []Availability `json:"avty,omitempty"`
+		AvailabilityMode        string         `json:"avty_mode,omitempty"`
+		Icon                    string         `json:"icon,omitempty"`
+		Device                  *Device        `json:"device,omitempty"`
+		Origin                  Origin         `json:"o"`
+	}{
+		UniqueID:                w.GetIdent(),
+		Name:                    w.GetName(),
+		ModeStateTopic:          w.GetStateTopic(),
+		ModeCommandTopic:        w.GetCommandTopic(),
+		TemperatureStateTopic:   w.GetTemperatureStateTopic(),
+		TemperatureCommandTopic: w.GetTemperatureCommandTopic(),
+		CurrentTemperatureTopic: w.GetCurrentTemperatureTopic(),
+		Modes:                   w.Modes,
+		MinTemp:                 w.MinTemp,
+		MaxTemp:                 w.MaxTemp,
+		TemperatureUnit:         w.TemperatureUnit,
+		Precision:               w.Precision,
+		Optimistic:              w.Optimistic,
+		AvailabilityTopic:       availabilityTopic,
+		Availability:            availability,
+		AvailabilityMode:        availabilityMode,
+		Icon:                    w.Icon,
+		Device:                  w.Device,
+		Origin:                  componentOrigin(w),
+	})
+}
+
+// GetDevice of water heater
+func (w *WaterHeater) GetDevice() *Device {
+	return w.Device
+}
+
+// SetDevice of water heater
+func (w *WaterHeater) SetDevice(device *Device) {
+	w.Device = device
+}
+
+// GetName of the water heater
+func (w *WaterHeater) GetName() string {
+	var name string
+	if w.Name == "" {
+		name = w.Ident
+	} else {
+		name = w.Name
+	}
+	if w.Device != nil {
+		return fmt.Sprintf("%s %s", w.Device.Name, name)
+	}
+	return name
+}
+
+// GetIdent of the water heater
+func (w *WaterHeater) GetIdent() string {
+	if w.Device == nil {
+		return w.Ident
+	}
+	return fmt.Sprintf("%s_%s", w.Device.Ident, w.Ident)
+}
+
+// GetPlatform returns the Home Assistant platform of the water heater
+func (w *WaterHeater) GetPlatform() string {
+	return "water_heater"
+}
+
+// GetBaseTopic for broker
+func (w *WaterHeater) GetBaseTopic() string {
+	return fmt.Sprintf("homeassistant/%s/%s", w.GetPlatform(), w.GetIdent())
+}
+
+// GetStateTopic returns state topic
+func (w *WaterHeater) GetStateTopic() string {
+	return fmt.Sprintf("%s/state", w.GetBaseTopic())
+}
+
+// GetAvailabilityTopic returns availability topic
+func (w *WaterHeater) GetAvailabilityTopic() string {
+	if w.Device == nil {
+		return fmt.Sprintf("%s/availability", w.GetBaseTopic())
+	}
+	return w.Device.GetAvailabilityTopic()
+}
+
+// GetEntityAvailabilityTopic returns the availability topic of the water heater itself
+func (w *WaterHeater) GetEntityAvailabilityTopic() string {
+	return fmt.Sprintf("%s/availability", w.GetBaseTopic())
+}
+
+// PublishAvailable send water heater availability message to broker
+func (w *WaterHeater) PublishAvailable(broker MQTT.Client) error {
+	return publishAvailability(broker, w.GetName(), w.GetEntityAvailabilityTopic(), payloadAvailable)
+}
+
+// PublishUnavailable send water heater unavailability message to broker
+func (w *WaterHeater) PublishUnavailable(broker MQTT.Client) error {
+	return publishAvailability(broker, w.GetName(), w.GetEntityAvailabilityTopic(), payloadNotAvailable)
+}
+
+// PublishDiscover publish discover payload to MQTT
+func (w *WaterHeater) PublishDiscover(broker MQTT.Client) error {
+	err := w.Validate()
+	if err != nil {
+		return err
+	}
+	payload, err := w.GetDiscoverPayload()
+	if err != nil {
+		return err
+	}
+	token := broker.Publish(w.GetDiscoverTopic(), 0, true, payload)
+	log.Infof("Publishing water heater %s discovery to %s", w.GetName(), w.GetDiscoverTopic())
+	log.Debug(string(payload))
+	token.Wait()
+	return nil
+}
+
+// GetDiscoverTopic returns discover topic
+func (w *WaterHeater) GetDiscoverTopic() string {
+	return fmt.Sprintf("%s/config", w.GetBaseTopic())
+}